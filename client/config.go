@@ -0,0 +1,119 @@
+// Package main provides the scanner configuration of the portquiz client.
+// It converts the command line flags to a scanner.Config.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/lanrat/portquiz/protocol"
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// scannerConfig returns the scanner configuration set by the command line flags.
+func scannerConfig() (scanner.Config, error) {
+	cfg := scanner.Config{
+		TCP:          *tcp,
+		UDP:          *udp,
+		IPv4:         *ipv4,
+		IPv6:         *ipv6,
+		Password:     *magicString,
+		Session:      *session,
+		Timeout:      *timeout,
+		Multi:        *multi,
+		Parallel:     *parallel,
+		Retry:        *retry,
+		RetryTCP:     *retryTCP,
+		RetryUDP:     *retryUDP,
+		Backoff:      *backoff,
+		BackoffMax:   *backoffMax,
+		Jitter:       *jitter,
+		AllAddrs:     *allAddrs,
+		Capabilities: targetCaps,
+		Logger:       slog.Default(),
+	}
+
+	if *session != "" && !protocol.ValidSession(*session) {
+		return cfg, errors.New("invalid -session: must be 1 to 64 letters, digits, '.', '_' or '-'")
+	}
+	var err error
+	cfg.Sources, err = parseSources()
+	if err != nil {
+		return cfg, fmt.Errorf("invalid source: %w", err)
+	}
+	cfg.SourcePorts, err = parseSourcePorts()
+	if err != nil {
+		return cfg, fmt.Errorf("invalid source port: %w", err)
+	}
+	if *proxyURL != "" {
+		cfg.Proxy, err = scanner.ParseProxy(*proxyURL)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid -proxy: %w", err)
+		}
+		// host names are resolved by the proxy, so their addresses and IP versions are unknown
+		for _, t := range targets {
+			if net.ParseIP(t) == nil && (*allAddrs || *ipv4 || *ipv6) {
+				return cfg, fmt.Errorf("-all-addrs, -4, -6 and -dual-stack can not be used with -proxy for host name %s, the proxy resolves it", t)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// parseSources returns the sources given by the -source-ip and -interface flags.
+// Each address and interface given is a separate source.
+func parseSources() ([]scanner.Source, error) {
+	var list []scanner.Source
+	for _, s := range strings.Split(*sourceIP, ",") {
+		if s == "" {
+			continue
+		}
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		list = append(list, scanner.Source{IP: ip})
+	}
+	for _, s := range strings.Split(*iface, ",") {
+		if s == "" {
+			continue
+		}
+		list = append(list, scanner.Source{Interface: s})
+	}
+	return list, nil
+}
+
+// parseSourcePorts returns the source ports given by the -source-port and -source-port-range flags.
+func parseSourcePorts() (protocol.PortRange, error) {
+	switch {
+	case *sourcePort != 0 && *sourcePortRange != "":
+		return protocol.PortRange{}, errors.New("-source-port and -source-port-range are mutually exclusive")
+	case *sourcePort != 0:
+		if *sourcePort > maxPort {
+			return protocol.PortRange{}, fmt.Errorf("port %d out of range", *sourcePort)
+		}
+		return protocol.PortRange{Start: int(*sourcePort), End: int(*sourcePort)}, nil
+	case *sourcePortRange != "":
+		startStr, endStr, ok := strings.Cut(*sourcePortRange, "-")
+		if !ok {
+			return protocol.PortRange{}, fmt.Errorf("invalid range %q, must be START-END", *sourcePortRange)
+		}
+		start, err := strconv.Atoi(startStr)
+		if err != nil {
+			return protocol.PortRange{}, err
+		}
+		end, err := strconv.Atoi(endStr)
+		if err != nil {
+			return protocol.PortRange{}, err
+		}
+		if start < 1 || end > maxPort || start > end {
+			return protocol.PortRange{}, fmt.Errorf("invalid range %d-%d", start, end)
+		}
+		return protocol.PortRange{Start: start, End: end}, nil
+	}
+	return protocol.PortRange{}, nil
+}
@@ -0,0 +1,133 @@
+// Package main provides the diff command for the portquiz client.
+// It compares two saved scan results to detect changes in firewall policy.
+// Results are matched by target, source, kind and port.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Exit codes of the diff command, matching diff(1).
+const (
+	diffExitSame    = 0 // The scans have the same results
+	diffExitChanged = 1 // The scans have different results
+	diffExitError   = 2 // The scans could not be compared
+)
+
+// diffKey identifies a result within a scan.
+type diffKey struct {
+	target  string
+	address string
+	source  string
+	kind    string
+	port    int
+}
+
+// diffChange describes how a single port changed between two scans.
+type diffChange struct {
+	key    diffKey
+	status string // OPENED, CLOSED, ADDED or REMOVED
+	open   bool   // Whether the port is open in the scan it is present in
+}
+
+// runDiff compares the scan results in the two files given as arguments and
+// prints every port whose state changed. It returns the process exit code.
+func runDiff(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: portquiz diff old.json new.json")
+		return diffExitError
+	}
+	oldDoc, err := readScanDocument(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return diffExitError
+	}
+	newDoc, err := readScanDocument(args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return diffExitError
+	}
+
+	// only name the target when comparing scans of several targets
+	multi := len(oldDoc.Targets) > 1 || len(newDoc.Targets) > 1
+	changes := diffScans(oldDoc, newDoc)
+	for _, c := range changes {
+		label := c.key.kind
+		if c.key.source != "" {
+			label = c.key.source + " " + label
+		}
+		if c.key.address != "" {
+			label = c.key.address + " " + label
+		}
+		if multi {
+			label = c.key.target + " " + label
+		}
+		switch c.status {
+		case "ADDED", "REMOVED":
+			fmt.Printf("%s %s %d %s\n", c.status, label, c.key.port, openString(c.open))
+		default:
+			fmt.Printf("%s %s %d\n", c.status, label, c.key.port)
+		}
+	}
+	if len(changes) > 0 {
+		return diffExitChanged
+	}
+	return diffExitSame
+}
+
+// diffScans returns the ports that were newly opened or closed between the two scans,
+// and those only tested in one of them, sorted by target, source, kind and port.
+// Addresses are only compared when both scans tested every address of their targets,
+// otherwise a target resolving to a new address is not a change.
+func diffScans(oldDoc, newDoc *scanDocument) []diffChange {
+	byAddr := oldDoc.AllAddrs && newDoc.AllAddrs
+	key := func(r resultRecord) diffKey {
+		k := diffKey{target: r.Target, source: r.Source, kind: r.Kind, port: r.Port}
+		if byAddr {
+			k.address = r.Address
+		}
+		return k
+	}
+	oldResults := make(map[diffKey]bool, len(oldDoc.Results))
+	for _, r := range oldDoc.Results {
+		oldResults[key(r)] = r.Open
+	}
+	newResults := make(map[diffKey]bool, len(newDoc.Results))
+	for _, r := range newDoc.Results {
+		newResults[key(r)] = r.Open
+	}
+
+	var changes []diffChange
+	for k, wasOpen := range oldResults {
+		isOpen, ok := newResults[k]
+		switch {
+		case !ok:
+			changes = append(changes, diffChange{key: k, status: "REMOVED", open: wasOpen})
+		case !wasOpen && isOpen:
+			changes = append(changes, diffChange{key: k, status: "OPENED", open: true})
+		case wasOpen && !isOpen:
+			changes = append(changes, diffChange{key: k, status: "CLOSED", open: false})
+		}
+	}
+	for k, isOpen := range newResults {
+		if _, ok := oldResults[k]; !ok {
+			changes = append(changes, diffChange{key: k, status: "ADDED", open: isOpen})
+		}
+	}
+	slices.SortFunc(changes, func(a, b diffChange) int {
+		return cmp.Or(cmp.Compare(a.key.target, b.key.target), cmp.Compare(a.key.address, b.key.address),
+			cmp.Compare(a.key.source, b.key.source), cmp.Compare(a.key.kind, b.key.kind), cmp.Compare(a.key.port, b.key.port))
+	})
+	return changes
+}
+
+// openString returns the text output name of a port state.
+func openString(open bool) string {
+	if open {
+		return "OPEN"
+	}
+	return "CLOSED"
+}
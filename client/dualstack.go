@@ -0,0 +1,113 @@
+// Package main provides the dual-stack comparison report for the portquiz client.
+// It shows the ports whose state differs between IPv4 and IPv6.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// dualStackKey identifies a port of a target tested over both IPv4 and IPv6.
+type dualStackKey struct {
+	target string
+	source string
+	proto  string
+	port   int
+}
+
+// familyState holds the results of a port over one IP version.
+type familyState struct {
+	tested bool
+	open   bool // Whether the port was open on every address of the IP version
+}
+
+// dualStackDiff is a port whose state differs between IPv4 and IPv6.
+type dualStackDiff struct {
+	key        dualStackKey
+	ipv4, ipv6 bool // Whether the port is open over each IP version
+}
+
+// ipv6Blocked reports whether the port is only blocked over IPv6,
+// a frequent misconfiguration where firewall rules are only written for IPv4.
+func (d dualStackDiff) ipv6Blocked() bool {
+	return d.ipv4 && !d.ipv6
+}
+
+// dualStackDiffs returns every port tested over both IPv4 and IPv6 whose state differs,
+// with those only blocked over IPv6 first.
+func dualStackDiffs(done []*scanner.Job) []dualStackDiff {
+	states := make(map[dualStackKey]*[2]familyState)
+	for _, j := range done {
+		var family int
+		switch {
+		case strings.HasSuffix(j.Kind, "4"):
+			family = 0
+		case strings.HasSuffix(j.Kind, "6"):
+			family = 1
+		default:
+			continue
+		}
+		k := dualStackKey{j.Target, j.Source.String(), strings.TrimRight(j.Kind, "46"), j.Port}
+		s, ok := states[k]
+		if !ok {
+			s = &[2]familyState{}
+			states[k] = s
+		}
+		if !s[family].tested {
+			s[family] = familyState{tested: true, open: j.Open}
+		} else {
+			s[family].open = s[family].open && j.Open
+		}
+	}
+
+	var diffs []dualStackDiff
+	for k, s := range states {
+		if s[0].tested && s[1].tested && s[0].open != s[1].open {
+			diffs = append(diffs, dualStackDiff{key: k, ipv4: s[0].open, ipv6: s[1].open})
+		}
+	}
+	slices.SortFunc(diffs, func(a, b dualStackDiff) int {
+		if a.ipv6Blocked() != b.ipv6Blocked() {
+			if a.ipv6Blocked() {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(cmp.Compare(a.key.target, b.key.target), cmp.Compare(a.key.source, b.key.source),
+			cmp.Compare(a.key.proto, b.key.proto),
+			cmp.Compare(a.key.port, b.key.port))
+	})
+	return diffs
+}
+
+// printDualStack prints the dual-stack comparison report of the completed jobs.
+func printDualStack(done []*scanner.Job) {
+	diffs := dualStackDiffs(done)
+	ipv6Only := 0
+	for _, d := range diffs {
+		if d.ipv6Blocked() {
+			ipv6Only++
+		}
+	}
+	fmt.Printf("DUALSTACK %d ports differ, %d blocked on IPv6 only, %d blocked on IPv4 only\n",
+		len(diffs), ipv6Only, len(diffs)-ipv6Only)
+	for _, d := range diffs {
+		label := d.key.proto
+		if len(sc.Sources()) > 1 {
+			label = d.key.source + " " + label
+		}
+		if len(targets) > 1 {
+			label = d.key.target + " " + label
+		}
+		blocked := "IPv4"
+		if d.ipv6Blocked() {
+			blocked = "IPv6"
+		}
+		fmt.Printf("DUALSTACK %s %d ipv4=%s ipv6=%s blocked on %s only\n",
+			label, d.key.port, openString(d.ipv4), openString(d.ipv6), blocked)
+	}
+}
@@ -0,0 +1,76 @@
+// Package main provides the exit status of the portquiz client.
+// It maps the outcome of a scan to documented exit codes so scripts can branch on them.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// Exit codes of the client. These are documented in the README and must not change.
+const (
+	exitAllOpen           = 0   // Every tested port is open, or the -expect policy matched
+	exitError             = 1   // The scan failed to run
+	exitUsage             = 2   // Invalid command line arguments
+	exitExpectationFailed = 3   // The results do not match the -expect policy
+	exitSomeClosed        = 4   // At least one tested port is closed, and at least one is open
+	exitNoneOpen          = 5   // No tested port is open, the server is likely unreachable
+	exitProtocolMismatch  = 6   // No tested port is open, and responses did not contain the password
+	exitInterrupted       = 130 // The scan was interrupted, the results are partial
+)
+
+// usageError prints the error and the command usage to stderr, then exits with exitUsage.
+func usageError(format string, a ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+	flag.Usage()
+	os.Exit(exitUsage)
+}
+
+// exitStatus returns the exit code describing the outcome of the completed jobs.
+// When -expect is set the code only reflects whether the policy matched.
+func exitStatus(done []*scanner.Job, violations int) int {
+	if expectations != nil {
+		if violations > 0 {
+			return exitExpectationFailed
+		}
+		return exitAllOpen
+	}
+
+	var openCount, closedCount int
+	mismatch := false
+	for _, j := range done {
+		if j.Open {
+			openCount++
+			continue
+		}
+		closedCount++
+		mismatch = mismatch || j.Mismatch()
+	}
+	switch {
+	case openCount == 0 && mismatch:
+		return exitProtocolMismatch
+	case openCount == 0:
+		return exitNoneOpen
+	case closedCount > 0:
+		return exitSomeClosed
+	}
+	return exitAllOpen
+}
+
+// exitPreflight exits with exitNoneOpen or exitProtocolMismatch if err shows the target's server
+// is unreachable, is not a portquiz server or rejected the password, and returns otherwise.
+func exitPreflight(target string, err error) {
+	switch {
+	case errors.Is(err, scanner.ErrUnreachable):
+		slog.Error("preflight failed", "remote", target, "error", err)
+		os.Exit(exitNoneOpen)
+	case errors.Is(err, scanner.ErrNotPortquiz), errors.Is(err, scanner.ErrPasswordMismatch):
+		slog.Error("preflight failed", "remote", target, "error", err)
+		os.Exit(exitProtocolMismatch)
+	}
+}
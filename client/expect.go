@@ -0,0 +1,178 @@
+// Package main provides expected policy assertions for the portquiz client.
+// It checks scan results against a file listing which ports must be open or closed.
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// expectations holds the rules loaded from the -expect file, nil when not set.
+var expectations []expectRule
+
+// expectRule is a single line of an expect file, such as "tcp 8000-8100 open".
+type expectRule struct {
+	line  int    // Line number in the expect file, for error messages
+	kind  string // Kind to match, "tcp" and "udp" also match their IPv4 and IPv6 kinds
+	start int    // First port of the range
+	end   int    // Last port of the range
+	open  bool   // Whether matching ports must be open
+}
+
+// matches reports whether the rule applies to the given kind and port.
+func (r expectRule) matches(kind string, port int) bool {
+	if port < r.start || port > r.end {
+		return false
+	}
+	if r.kind == kind {
+		return true
+	}
+	return (r.kind == "tcp" || r.kind == "udp") && strings.HasPrefix(kind, r.kind)
+}
+
+// loadExpectations reads the expect file at path.
+func loadExpectations(path string) ([]expectRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := parseExpectations(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// parseExpectations parses expect rules, one per line in the form "KIND PORT[-PORT] open|closed".
+// Rules apply to every target.
+// Blank lines and lines starting with # are ignored.
+func parseExpectations(r io.Reader) ([]expectRule, error) {
+	var rules []expectRule
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected \"KIND PORT[-PORT] open|closed\", got %q", n, line)
+		}
+
+		rule := expectRule{line: n, kind: strings.ToLower(fields[0])}
+		switch rule.kind {
+		case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		default:
+			return nil, fmt.Errorf("line %d: unknown kind %q", n, fields[0])
+		}
+
+		startPort, endPort, isRange := strings.Cut(fields[1], "-")
+		var err error
+		rule.start, err = strconv.Atoi(startPort)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid port %q", n, fields[1])
+		}
+		rule.end = rule.start
+		if isRange {
+			rule.end, err = strconv.Atoi(endPort)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid port %q", n, fields[1])
+			}
+		}
+		if rule.start < 1 || rule.end > maxPort || rule.start > rule.end {
+			return nil, fmt.Errorf("line %d: invalid port range %q", n, fields[1])
+		}
+
+		switch strings.ToLower(fields[2]) {
+		case "open":
+			rule.open = true
+		case "closed":
+			rule.open = false
+		default:
+			return nil, fmt.Errorf("line %d: expected open or closed, got %q", n, fields[2])
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// violation describes a port whose result does not match the expected policy,
+// or a rule that did not match any tested port.
+type violation struct {
+	label  string // Label of the job in output, empty for rules not tested
+	kind   string
+	port   int
+	rule   expectRule
+	tested bool // Whether the port was tested at all
+	open   bool // Whether the port was found open, if tested
+}
+
+// String formats the violation for output.
+func (v violation) String() string {
+	if !v.tested {
+		ports := strconv.Itoa(v.rule.start)
+		if v.rule.end != v.rule.start {
+			ports += "-" + strconv.Itoa(v.rule.end)
+		}
+		return fmt.Sprintf("VIOLATION %s %s expected %s, not tested (line %d)",
+			v.rule.kind, ports, openString(v.rule.open), v.rule.line)
+	}
+	return fmt.Sprintf("VIOLATION %s %d expected %s, got %s (line %d)",
+		v.label, v.port, openString(v.rule.open), openString(v.open), v.rule.line)
+}
+
+// checkExpectations compares the completed jobs against the rules and returns every mismatch.
+// When several rules match a port the last one wins, so broad ranges can be refined by
+// later lines. A rule that does not apply to any tested port is also a violation, so an
+// expectation can not silently pass because its ports were not scanned.
+func checkExpectations(rules []expectRule, done []*scanner.Job) []violation {
+	var violations []violation
+	used := make([]bool, len(rules))
+	for _, j := range done {
+		for i := len(rules) - 1; i >= 0; i-- {
+			r := rules[i]
+			if !r.matches(j.Kind, j.Port) {
+				continue
+			}
+			used[i] = true
+			if j.Open != r.open {
+				violations = append(violations, violation{label: label(j), kind: j.Kind, port: j.Port, rule: r, tested: true, open: j.Open})
+			}
+			break
+		}
+	}
+	for i, r := range rules {
+		if !used[i] {
+			violations = append(violations, violation{kind: r.kind, port: r.start, rule: r})
+		}
+	}
+
+	slices.SortFunc(violations, func(a, b violation) int {
+		return cmp.Or(cmp.Compare(a.label, b.label), cmp.Compare(a.kind, b.kind), cmp.Compare(a.port, b.port))
+	})
+	return violations
+}
+
+// reportExpectations prints every violation of the -expect rules by the completed jobs.
+// It returns the number of violations.
+func reportExpectations(done []*scanner.Job) int {
+	// keep stdout a valid document for machine readable formats
+	out := os.Stdout
+	if *format != formatText {
+		out = os.Stderr
+	}
+	violations := checkExpectations(expectations, done)
+	for _, v := range violations {
+		fmt.Fprintln(out, v)
+	}
+	return len(violations)
+}
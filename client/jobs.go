@@ -1,177 +1,146 @@
-// Package main provides job management and worker functionality for the portquiz client.
-// It handles the creation, distribution, and processing of port testing jobs.
+// Package main provides job output for the portquiz client.
+// It prints each job as it completes and the requested reports once the scan is done.
 package main
 
 import (
-	"context"
 	"fmt"
 	"strconv"
 	"strings"
-	"sync"
+
+	"github.com/lanrat/portquiz/scanner"
 )
 
-// job represents a single port testing task.
-type job struct {
-	try  uint   // Current retry attempt number
-	kind string // Protocol and IP version (e.g., "tcp4", "udp6")
-	port int    // Port number to test
-	open bool   // Whether the port was found to be open
+// label returns the name of the job's kind in output, prefixed by the target
+// when more than one target is tested, by the address with -all-addrs,
+// and by the source when more than one source is tested.
+func label(j *scanner.Job) string {
+	parts := make([]string, 0, 4)
+	if len(targets) > 1 {
+		parts = append(parts, j.Target)
+	}
+	if *allAddrs {
+		parts = append(parts, j.Addr)
+	}
+	if len(sc.Sources()) > 1 {
+		parts = append(parts, j.Source.String())
+	}
+	return strings.Join(append(parts, j.Kind), " ")
 }
 
-// wg tracks all active jobs to ensure proper shutdown.
-var wg sync.WaitGroup
-
-// versions returns the IP version suffixes to use based on command line flags.
-// Returns:
-//   - [""] for default (both IPv4 and IPv6)
-//   - ["4"] for IPv4 only
-//   - ["6"] for IPv6 only
-//   - ["4", "6"] for both explicitly
-func versions() []string {
-	if !*ipv4 && !*ipv6 {
-		return []string{""}
-	}
-	if *ipv4 && !*ipv6 {
-		return []string{"4"}
-	}
-	if !*ipv4 && *ipv6 {
-		return []string{"6"}
-	}
-	return []string{"4", "6"}
+// destination returns the name of the target, with the address with -all-addrs
+// and the source when more than one source is tested.
+func destination(j *scanner.Job) string {
+	d := j.Target
+	if *allAddrs {
+		d += " " + j.Addr
+	}
+	if len(sc.Sources()) > 1 {
+		d += " " + j.Source.String()
+	}
+	return d
 }
 
-// jobSource generates port testing jobs and sends them to the jobs channel.
-// It creates jobs for the specified port range or individual ports based on command line arguments.
-func jobSource(ctx context.Context, jobs, results chan *job) error {
-	start := 1
-	end := maxPort
-	addJob := func(p int, ver []string) error {
-		for _, v := range ver {
-			if *tcp {
-				jTCP := &job{
-					try:  0,
-					kind: "tcp" + v,
-					port: p,
-				}
-				wg.Add(1)
-				select {
-				case <-ctx.Done():
-					return nil
-				case jobs <- jTCP:
-				}
-			}
-			if *udp {
-				jUDP := &job{
-					try:  0,
-					kind: "udp" + v,
-					port: p,
-				}
-				wg.Add(1)
-				select {
-				case <-ctx.Done():
-					return nil
-				case jobs <- jUDP:
-				}
-			}
-		}
-		return nil
-	}
-	ver := versions()
+// ports returns the list of ports to test, either every port or those given by -port.
+func ports() ([]int, error) {
 	if *port == "" {
-		for p := start; p <= end; p++ {
-			err := addJob(p, ver)
-			if err != nil {
-				return err
-			}
-		}
-	} else {
-		ports := strings.Split(*port, ",")
-		for _, ps := range ports {
-			if ps == "" {
-				continue
-			}
-			p, err := strconv.Atoi(ps)
-			if err != nil {
-				return err
-			}
-			err = addJob(p, ver)
-			if err != nil {
-				return err
-			}
+		all := make([]int, 0, maxPort)
+		for p := 1; p <= maxPort; p++ {
+			all = append(all, p)
 		}
+		return all, nil
 	}
-	close(jobs)
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	return nil
+	return portList(*port)
 }
 
-// worker processes jobs from the jobs channel and sends results to the results channel.
-// It performs the actual port connectivity tests and retries failed attempts.
-func worker(ctx context.Context, jobs, results chan *job) error {
-	//var a sync.WaitGroup
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case j, ok := <-jobs:
-			if !ok {
-				// done
-				return nil
-			}
-
-			try := func() error {
-				switch {
-				case strings.HasPrefix(j.kind, "tcp"):
-					if isOpenTCPMulti(ctx, j.port, j.kind) {
-						j.open = true
-					}
-				case strings.HasPrefix(j.kind, "udp"):
-					if isOpenUDPMulti(ctx, j.port, j.kind) {
-						j.open = true
-					}
-				default:
-					return fmt.Errorf("unknown kind: %s", j.kind)
-				}
-				return nil
-			}
+// controlPorts returns the ports given by -control-port.
+func controlPorts() ([]int, error) {
+	return portList(*controlPort)
+}
 
-			for ; j.try < *retry && !j.open; j.try++ {
-				err := try()
-				if err != nil {
-					return err
-				}
-			}
+// portList parses a comma separated list of ports.
+func portList(s string) ([]int, error) {
+	var list []int
+	for _, ps := range strings.Split(s, ",") {
+		if ps == "" {
+			continue
+		}
+		p, err := strconv.Atoi(ps)
+		if err != nil {
+			return nil, err
+		}
+		if p < 1 || p > maxPort {
+			return nil, fmt.Errorf("port %d out of range", p)
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
 
-			results <- j
+// resume marks the jobs completed in the state file as resumed so they are not tested again.
+func resume(list []*scanner.Job) {
+	for _, j := range list {
+		if open, ok := state.lookup(j); ok {
+			j.Open = open
+			j.Resumed = true
 		}
 	}
 }
 
-// jobResults processes completed jobs from the results channel and prints the output.
-// It formats and displays the results based on the open/closed flags.
-func jobResults(ctx context.Context, results chan *job) error {
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case j, ok := <-results:
-			if !ok {
-				// channel closed
-				return nil
-			}
-			if j.open {
-				if *open {
-					fmt.Printf("OPEN %s %d\n", j.kind, j.port)
-				}
+// handleEvent prints and records the jobs of the scan as they complete.
+func handleEvent(e scanner.Event) error {
+	switch e.Type {
+	case scanner.EventJobQueued:
+		prog.jobQueued()
+	case scanner.EventJobDone:
+		return jobDone(e.Job)
+	}
+	return nil
+}
+
+// jobDone records the completed job in the state file and prints it in text format.
+func jobDone(j *scanner.Job) error {
+	prog.jobDone(j)
+	if !j.Resumed {
+		if err := state.record(j); err != nil {
+			return err
+		}
+	}
+	if *format != formatText {
+		// other formats are printed once all jobs are complete
+	} else if j.Open {
+		if *open {
+			if n := len(j.Attempts); n > 1 {
+				prog.printf("OPEN %s %d (attempt %d)\n", label(j), j.Port, n)
 			} else {
-				if *closed {
-					fmt.Printf("CLOSED %s %d\n", j.kind, j.port)
-				}
+				prog.printf("OPEN %s %d\n", label(j), j.Port)
 			}
-			wg.Done()
 		}
+	} else {
+		if *closed {
+			prog.printf("CLOSED %s %d\n", label(j), j.Port)
+		}
+	}
+	return nil
+}
+
+// printReports prints the summary or JSON document of the completed scan if requested.
+// When the scan was interrupted the reports are marked as partial with the number of jobs not completed.
+func printReports(done []*scanner.Job, interrupted bool, notCompleted int) error {
+	prog.finish()
+	if *format == formatJSON {
+		return printJSON(done, interrupted, notCompleted)
 	}
+	if interrupted {
+		fmt.Printf("INTERRUPTED %d of %d jobs not completed\n", notCompleted, notCompleted+len(done))
+	}
+	if *summary {
+		printSummary(done)
+	}
+	if len(destinations()) > 1 {
+		printMatrix(done)
+	}
+	if *dualStack {
+		printDualStack(done)
+	}
+	return nil
 }
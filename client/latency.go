@@ -0,0 +1,115 @@
+// Package main provides latency measurement and reporting for the portquiz client.
+// It summarizes probe timings per kind and flags ports with unusual round-trip times.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// latency returns the timing of the probe that found the job open.
+// It returns false if the job is not open.
+func latency(j *scanner.Job) (scanner.Probe, bool) {
+	if !j.Open || len(j.Attempts) == 0 {
+		return scanner.Probe{}, false
+	}
+	probes := j.Attempts[len(j.Attempts)-1].Probes
+	if len(probes) == 0 {
+		return scanner.Probe{}, false
+	}
+	return probes[len(probes)-1], true
+}
+
+// latencyStats holds the distribution of a set of durations.
+type latencyStats struct {
+	min, median, p95, max time.Duration
+}
+
+// newLatencyStats computes the distribution of the given durations.
+// The durations slice is sorted in place.
+func newLatencyStats(d []time.Duration) latencyStats {
+	if len(d) == 0 {
+		return latencyStats{}
+	}
+	slices.Sort(d)
+	return latencyStats{
+		min:    d[0],
+		median: percentile(d, 50),
+		p95:    percentile(d, 95),
+		max:    d[len(d)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank-1, 0)]
+}
+
+// String formats the distribution for the summary output.
+func (s latencyStats) String() string {
+	return fmt.Sprintf("min=%s median=%s p95=%s max=%s",
+		s.min.Round(time.Microsecond), s.median.Round(time.Microsecond),
+		s.p95.Round(time.Microsecond), s.max.Round(time.Microsecond))
+}
+
+// printSummary prints the end of scan summary of the completed jobs.
+// For each kind, and target when more than one is tested, it prints the open and closed counts, the latency distribution of
+// open ports, and any port whose round-trip time is an outlier versus the median.
+func printSummary(done []*scanner.Job) {
+	var kinds []string
+	byKind := make(map[string][]*scanner.Job)
+	for _, j := range done {
+		if _, ok := byKind[label(j)]; !ok {
+			kinds = append(kinds, label(j))
+		}
+		byKind[label(j)] = append(byKind[label(j)], j)
+	}
+	slices.Sort(kinds)
+
+	for _, kind := range kinds {
+		slices.SortFunc(byKind[kind], func(a, b *scanner.Job) int { return cmp.Compare(a.Port, b.Port) })
+		var openCount, closedCount int
+		var connect, firstByte, total []time.Duration
+		for _, j := range byKind[kind] {
+			if !j.Open {
+				closedCount++
+				continue
+			}
+			openCount++
+			// jobs resumed from a state file have no timing
+			p, ok := latency(j)
+			if !ok {
+				continue
+			}
+			connect = append(connect, p.Connect)
+			firstByte = append(firstByte, p.FirstByte)
+			total = append(total, p.Total)
+		}
+		fmt.Printf("SUMMARY %s open=%d closed=%d\n", kind, openCount, closedCount)
+		if len(total) == 0 {
+			continue
+		}
+		fmt.Printf("LATENCY %s connect %s\n", kind, newLatencyStats(connect))
+		fmt.Printf("LATENCY %s first-byte %s\n", kind, newLatencyStats(firstByte))
+		rtt := newLatencyStats(total)
+		fmt.Printf("LATENCY %s rtt %s\n", kind, rtt)
+
+		if *outlier <= 1 || rtt.median <= 0 {
+			continue
+		}
+		high := time.Duration(float64(rtt.median) * *outlier)
+		low := time.Duration(float64(rtt.median) / *outlier)
+		for _, j := range byKind[kind] {
+			p, ok := latency(j)
+			if ok && (p.Total > high || p.Total < low) {
+				fmt.Printf("OUTLIER %s %d rtt=%s median=%s\n", kind, j.Port,
+					p.Total.Round(time.Microsecond), rtt.median.Round(time.Microsecond))
+			}
+		}
+	}
+}
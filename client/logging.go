@@ -0,0 +1,13 @@
+// Package main provides fatal error handling for the portquiz client.
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// fatal logs msg and its attributes at error level, then exits with exitError.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(exitError)
+}
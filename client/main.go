@@ -7,43 +7,71 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"golang.org/x/sync/errgroup"
+	"github.com/lanrat/portquiz/internal/logging"
+	"github.com/lanrat/portquiz/protocol"
+	"github.com/lanrat/portquiz/scanner"
 )
 
 var (
-	tcp         = flag.Bool("tcp", false, "start TCP client")
-	udp         = flag.Bool("udp", false, "start UDP client")
-	verbose     = flag.Bool("verbose", false, "enable verbose logging")
-	timeout     = flag.Duration("timeout", time.Second*5, "amount of time for each connection")
-	retry       = flag.Uint("retry", 3, "retry count")
-	parallel    = flag.Uint("parallel", 20, "number of worker threads")
-	open        = flag.Bool("open", false, "print only open ports")
-	closed      = flag.Bool("closed", false, "print only closed ports")
-	port        = flag.String("port", "", "comma separated list of ports to test")
-	multi       = flag.Uint("multi", 1, "test multiple times to ensure larger streams work")
-	ipv4        = flag.Bool("4", false, "force IPv4")
-	ipv6        = flag.Bool("6", false, "force IPv6")
-	magicString = flag.String("password", "portquiz", "magicString to use, must be the same on client/server")
-	version     = flag.Bool("version", false, "show version information")
+	tcp             = flag.Bool("tcp", false, "start TCP client, defaults to what the server supports")
+	udp             = flag.Bool("udp", false, "start UDP client, defaults to what the server supports")
+	verbose         = flag.Bool("verbose", false, "enable verbose logging, same as -log-level debug")
+	logFormat       = flag.String("log-format", logging.FormatText, "log format, text or json")
+	logLevel        = flag.String("log-level", "info", "minimum level of messages to log, debug, info, warn or error")
+	timeout         = flag.Duration("timeout", time.Second*5, "amount of time for each connection")
+	retry           = flag.Uint("retry", 3, "retry count")
+	retryTCP        = flag.Uint("retry-tcp", 0, "retry count for TCP, overrides -retry when set")
+	retryUDP        = flag.Uint("retry-udp", 0, "retry count for UDP, overrides -retry when set")
+	backoff         = flag.Duration("backoff", 0, "initial delay between retries, doubled after each attempt, 0 to retry immediately")
+	backoffMax      = flag.Duration("backoff-max", time.Second*5, "maximum delay between retries")
+	jitter          = flag.Float64("jitter", 0.5, "fraction of the retry delay to randomize (0-1)")
+	parallel        = flag.Uint("parallel", 20, "number of worker threads")
+	open            = flag.Bool("open", false, "print only open ports, JSON documents always include every port")
+	closed          = flag.Bool("closed", false, "print only closed ports, JSON documents always include every port")
+	port            = flag.String("port", "", "comma separated list of ports to test")
+	multi           = flag.Uint("multi", 1, "test multiple times to ensure larger streams work")
+	ipv4            = flag.Bool("4", false, "force IPv4")
+	ipv6            = flag.Bool("6", false, "force IPv6")
+	dualStack       = flag.Bool("dual-stack", false, "test over both IPv4 and IPv6 and report ports where they differ")
+	magicString     = flag.String("password", "portquiz", "magicString to use, must be the same on client/server")
+	session         = flag.String("session", "", "ID the server records this scan's probes under, reported at /sessions/ID on the server's -stats-listen address")
+	summary         = flag.Bool("summary", false, "print per-kind counts and latency summary at the end of the scan")
+	outlier         = flag.Float64("outlier", 3, "flag open ports whose round-trip time differs from the median by this factor in the summary")
+	noProgress      = flag.Bool("no-progress", false, "disable the progress display shown on stderr when it is a terminal")
+	controlPort     = flag.String("control-port", "1337", "comma separated list of ports checked before scanning to verify the server and password, must include the server -port")
+	noPreflight     = flag.Bool("no-preflight", false, "skip checking the server, password and server capabilities before scanning")
+	expectFile      = flag.String("expect", "", "file of expected port states to check results against")
+	format          = flag.String("format", formatText, "output format, text or json")
+	allAddrs        = flag.Bool("all-addrs", false, "test every resolved address of the targets instead of the first for each IP version")
+	stateFilePath   = flag.String("state", "", "file to record completed jobs in, to resume an interrupted scan")
+	sourceIP        = flag.String("source-ip", "", "comma separated list of local addresses to send probes from, each is tested separately")
+	iface           = flag.String("interface", "", "comma separated list of network interfaces to send probes from, each is tested separately (Linux only)")
+	sourcePort      = flag.Uint("source-port", 0, "local port to send probes from")
+	sourcePortRange = flag.String("source-port-range", "", "range of local ports to send probes from, e.g. 1024-2047")
+	proxyURL        = flag.String("proxy", "", "test through a proxy, socks5://[user:pass@]host:port or http://[user:pass@]host:port")
+	pushGateway     = flag.String("push", "", "Prometheus Pushgateway URL to push the scan results and timings to, e.g. http://pushgateway:9091")
+	pushJob         = flag.String("push-job", "portquiz", "job label of the metrics pushed with -push")
+	pushInstance    = flag.String("push-instance", "", "instance label of the metrics pushed with -push, defaults to the hostname")
+	version         = flag.Bool("version", false, "show version information")
 )
 
 var (
-	g                *errgroup.Group
-	ctx              context.Context
-	server           string
-	magicStringBytes []byte
-	Version          = "dev"
+	sc         *scanner.Scanner
+	targets    []string
+	targetCaps = make(map[string]*protocol.Capabilities) // Capabilities reported by each target's server
+	Version    = "dev"
 )
 
 // maxPort defines the maximum valid port number.
 const maxPort = 65535
 
-// main initializes the client, parses arguments, and starts the port testing workflow.
-// It sets up worker goroutines and manages the job queue for testing ports.
+// main initializes the client, parses arguments, and runs the scan.
 func main() {
 	flag.Parse()
 
@@ -52,12 +80,32 @@ func main() {
 		os.Exit(0)
 	}
 
-	magicStringBytes = []byte(*magicString)
+	if flag.Arg(0) == "diff" {
+		os.Exit(runDiff(flag.Args()[1:]))
+	}
+
+	logger, err := logging.New(*logFormat, *logLevel, *verbose)
+	if err != nil {
+		usageError("%s", err)
+	}
+	slog.SetDefault(logger)
 
-	if flag.NArg() != 1 {
-		log.Fatalf("Pass IP/host to connect to")
+	if err := checkFormat(*format); err != nil {
+		usageError("%s", err)
+	}
+	if err := checkPush(); err != nil {
+		usageError("%s", err)
+	}
+
+	if flag.NArg() < 1 {
+		usageError("Pass IP/host(s) to connect to")
+	}
+	targets = flag.Args()
+
+	if *dualStack {
+		*ipv4 = true
+		*ipv6 = true
 	}
-	server = flag.Arg(0)
 
 	// if open or closed not set, enable both
 	if !*open && !*closed {
@@ -65,38 +113,184 @@ func main() {
 		*closed = true
 	}
 
+	portList, err := ports()
+	if err != nil {
+		usageError("invalid -port: %s", err)
+	}
+
+	cports, err := controlPorts()
+	if err != nil {
+		usageError("invalid -control-port: %s", err)
+	}
+
+	cfg, err := scannerConfig()
+	if err != nil {
+		usageError("%s", err)
+	}
+	sc, err = scanner.New(cfg)
+	if err != nil {
+		usageError("invalid source: %s", err)
+	}
+
+	// cancel the scan on interrupt so completed jobs are flushed before exiting
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// restore the default behavior so a second interrupt exits immediately
+		<-sigCtx.Done()
+		stop()
+	}()
+
+	if err := sc.CheckSources(); err != nil {
+		fatal("source unavailable", "error", err)
+	}
+	if err := sc.CheckSourcePorts(); err != nil {
+		fatal("source port unavailable", "error", err)
+	}
+
+	capsErrs := make(map[string]error)
+	if !*noPreflight {
+		for _, t := range targets {
+			caps, err := sc.QueryCapabilities(sigCtx, t, cports)
+			if err != nil {
+				slog.Debug("server capabilities unavailable", "remote", t, "error", err)
+				capsErrs[t] = err
+				continue
+			}
+			targetCaps[t] = caps
+		}
+	}
+
 	if !*tcp && !*udp {
-		err := errors.New("must set TCP and/or UDP")
-		if err != nil {
-			log.Fatal(err)
+		if len(targetCaps) == 0 {
+			// the protocols come from the server, so report why it did not answer
+			for _, t := range targets {
+				exitPreflight(t, capsErrs[t])
+			}
+			usageError("must set TCP and/or UDP")
+		}
+		for _, caps := range targetCaps {
+			*tcp = *tcp || caps.TCP
+			*udp = *udp || caps.UDP
 		}
 	}
+	if *udp && !cfg.Proxy.SupportsUDP() {
+		if !*tcp {
+			usageError("UDP can only be tested through a socks5 proxy")
+		}
+		slog.Warn("proxy does not support UDP, skipping UDP", "proxy", cfg.Proxy.Scheme())
+		*udp = false
+	}
+	cfg.TCP, cfg.UDP = *tcp, *udp
+	sc, err = scanner.New(cfg)
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
 
-	g, ctx = errgroup.WithContext(context.Background())
+	usable := false
+	for _, t := range targets {
+		caps := targetCaps[t]
+		for _, kind := range sc.Kinds() {
+			if caps.Supports(kind) {
+				usable = true
+			} else {
+				slog.Warn("server does not support kind, skipping it", "remote", t, "kind", kind)
+			}
+		}
+		if caps != nil && !caps.Redirect {
+			slog.Warn("server does not redirect ports, only testing its port", "remote", t, "port", caps.Port)
+		}
+		skipped := 0
+		for _, p := range portList {
+			if caps.Excludes(p) {
+				skipped++
+			}
+		}
+		if skipped > 0 {
+			slog.Info("skipping ports the server does not redirect", "remote", t, "ports", skipped)
+		}
+	}
+	if !usable {
+		fatal("server does not support any requested protocol")
+	}
 
-	jobs := make(chan *job, 100)
-	results := make(chan *job, 100)
+	if !*noPreflight {
+		for _, t := range targets {
+			err = sc.Preflight(sigCtx, t, cports)
+			exitPreflight(t, err)
+			if err != nil {
+				fatal("preflight failed", "remote", t, "error", err)
+			}
+		}
+	}
 
-	// start putting ports into queue
-	g.Go(func() error {
-		return jobSource(ctx, jobs, results)
-	})
+	if err := sc.Resolve(sigCtx, targets); err != nil {
+		slog.Error("resolving targets failed", "error", err)
+		os.Exit(exitNoneOpen)
+	}
+	if err := checkAddrs(); err != nil {
+		slog.Error("no addresses to test", "error", err)
+		os.Exit(exitNoneOpen)
+	}
 
-	// start workers
-	for i := uint(0); i < *parallel; i++ {
-		g.Go(func() error {
-			return worker(ctx, jobs, results)
-		})
+	if *expectFile != "" {
+		expectations, err = loadExpectations(*expectFile)
+		if err != nil {
+			fatal("loading -expect failed", "error", err)
+		}
 	}
 
-	// start results
-	g.Go(func() error {
-		return jobResults(ctx, results)
-	})
+	if *stateFilePath != "" {
+		state, err = openState(*stateFilePath)
+		if err != nil {
+			fatal("opening -state failed", "error", err)
+		}
+	}
+
+	jobs := sc.Plan(targets, portList)
+	resume(jobs)
+
+	// the progress display shares stderr with debug logging, so only one is shown
+	runCtx, cancel := context.WithCancel(sigCtx)
+	if !*noProgress && !logger.Enabled(runCtx, slog.LevelDebug) && stderrIsTerminal() {
+		prog = newProgress()
+		prog.setTotal(len(jobs))
+		go prog.run(runCtx)
+	}
 
-	err := g.Wait()
+	start := time.Now()
+	done, err := sc.Run(runCtx, jobs, handleEvent)
+	took := time.Since(start)
+	cancel()
+	interrupted := sigCtx.Err() != nil
+	if err == nil {
+		err = printReports(done, interrupted, len(jobs)-len(done))
+	}
+	if err == nil && *pushGateway != "" {
+		// push partial results too, the scan was interrupted so sigCtx is already canceled
+		if pushErr := push(context.Background(), done, took, interrupted, len(jobs)-len(done)); pushErr != nil {
+			err = fmt.Errorf("pushing metrics failed: %w", pushErr)
+		}
+	}
+	if closeErr := state.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
 	if err != nil {
-		log.Fatal(err)
+		fatal("scan failed", "error", err)
+	}
+	if interrupted {
+		if state != nil {
+			slog.Warn("scan interrupted, run again with -state to resume", "not_completed", len(jobs)-len(done), "jobs", len(jobs), "state", *stateFilePath)
+		} else {
+			slog.Warn("scan interrupted", "not_completed", len(jobs)-len(done), "jobs", len(jobs))
+		}
+		// partial results say nothing about the untested ports, nor about the -expect policy
+		os.Exit(exitInterrupted)
 	}
 
+	violations := 0
+	if expectations != nil {
+		violations = reportExpectations(done)
+	}
+	os.Exit(exitStatus(done, violations))
 }
@@ -0,0 +1,73 @@
+// Package main provides the cross-target matrix for the portquiz client.
+// It shows the ports whose state differs between the targets, or target addresses
+// with -all-addrs, tested in one run.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// matrixKey identifies a port tested on every destination.
+type matrixKey struct {
+	kind string
+	port int
+}
+
+// printMatrix prints a table of every kind and port whose state is not the same on all
+// destinations, with a column per destination. Ports not tested on a destination are shown as "-".
+func printMatrix(done []*scanner.Job) {
+	dests := destinations()
+	states := make(map[matrixKey]map[string]bool)
+	for _, j := range done {
+		k := matrixKey{j.Kind, j.Port}
+		if states[k] == nil {
+			states[k] = make(map[string]bool, len(dests))
+		}
+		states[k][destination(j)] = j.Open
+	}
+
+	var differ []matrixKey
+	for k, byDest := range states {
+		first, same := byDest[dests[0]], len(byDest) == len(dests)
+		for _, d := range dests[1:] {
+			if open, ok := byDest[d]; ok && open != first {
+				same = false
+			}
+		}
+		if !same {
+			differ = append(differ, k)
+		}
+	}
+	slices.SortFunc(differ, func(a, b matrixKey) int {
+		return cmp.Or(cmp.Compare(a.kind, b.kind), cmp.Compare(a.port, b.port))
+	})
+
+	if len(differ) == 0 {
+		fmt.Println("MATRIX all destinations agree")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "MATRIX\tKIND\tPORT")
+	for _, d := range dests {
+		fmt.Fprintf(w, "\t%s", d)
+	}
+	fmt.Fprintln(w)
+	for _, k := range differ {
+		fmt.Fprintf(w, "MATRIX\t%s\t%d", k.kind, k.port)
+		for _, d := range dests {
+			state := "-"
+			if open, ok := states[k][d]; ok {
+				state = openString(open)
+			}
+			fmt.Fprintf(w, "\t%s", state)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
@@ -0,0 +1,117 @@
+// Package main provides machine readable output for the portquiz client.
+// It defines the JSON result document written by -format json and read by the diff command.
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// Output formats supported by the -format flag.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// scanDocument is the JSON document describing the results of a scan.
+type scanDocument struct {
+	Targets      []string       `json:"targets"`
+	AllAddrs     bool           `json:"all_addresses"` // Whether every address of the targets was tested
+	Sources      []string       `json:"sources,omitempty"`
+	Version      string         `json:"version"`
+	Started      time.Time      `json:"started"`
+	Finished     time.Time      `json:"finished"`
+	Interrupted  bool           `json:"interrupted,omitempty"`   // Whether the scan was interrupted, the results are partial when set
+	NotCompleted int            `json:"not_completed,omitempty"` // Number of jobs not completed when interrupted
+	Results      []resultRecord `json:"results"`
+}
+
+// resultRecord is the result of a single job in a scanDocument.
+type resultRecord struct {
+	Target   string  `json:"target"`
+	Address  string  `json:"address"`
+	Source   string  `json:"source,omitempty"`
+	Kind     string  `json:"kind"`
+	Port     int     `json:"port"`
+	Open     bool    `json:"open"`
+	Attempts int     `json:"attempts,omitempty"`
+	RTTms    float64 `json:"rtt_ms,omitempty"`
+}
+
+// scanStarted records when the scan started for the JSON document.
+var scanStarted = time.Now()
+
+// checkFormat returns an error if the output format is not supported.
+func checkFormat(format string) error {
+	switch format {
+	case formatText, formatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// printJSON writes the completed jobs to stdout as a scanDocument.
+// Every job is included regardless of -open and -closed, so documents can always be compared
+// with the diff command, and they are sorted by target, source, kind and port.
+// When the scan was interrupted the document is marked with the number of jobs not completed.
+func printJSON(done []*scanner.Job, interrupted bool, notCompleted int) error {
+	doc := scanDocument{
+		Targets:     targets,
+		AllAddrs:    *allAddrs,
+		Version:     Version,
+		Started:     scanStarted,
+		Finished:    time.Now(),
+		Interrupted: interrupted,
+		Results:     make([]resultRecord, 0, len(done)),
+	}
+	if interrupted {
+		doc.NotCompleted = notCompleted
+	}
+	for _, src := range sc.Sources() {
+		if src.String() != "" {
+			doc.Sources = append(doc.Sources, src.String())
+		}
+	}
+	for _, j := range done {
+		r := resultRecord{
+			Target:   j.Target,
+			Address:  j.Addr,
+			Source:   j.Source.String(),
+			Kind:     j.Kind,
+			Port:     j.Port,
+			Open:     j.Open,
+			Attempts: len(j.Attempts),
+		}
+		if p, ok := latency(j); ok {
+			r.RTTms = float64(p.Total) / float64(time.Millisecond)
+		}
+		doc.Results = append(doc.Results, r)
+	}
+	slices.SortFunc(doc.Results, func(a, b resultRecord) int {
+		return cmp.Or(cmp.Compare(a.Target, b.Target), cmp.Compare(a.Address, b.Address),
+			cmp.Compare(a.Source, b.Source), cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Port, b.Port))
+	})
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// readScanDocument reads a scanDocument previously written with -format json.
+func readScanDocument(path string) (*scanDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc scanDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &doc, nil
+}
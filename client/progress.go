@@ -0,0 +1,211 @@
+// Package main provides the live progress display for the portquiz client.
+// It renders job counters, the current rate, and an ETA on stderr while scanning.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// progressInterval is how often the progress line is redrawn.
+const progressInterval = 250 * time.Millisecond
+
+// prog is the active progress display, nil when disabled.
+var prog *progress
+
+// kindCount holds the open and closed counts for a single kind.
+type kindCount struct {
+	open, closed int
+}
+
+// progress tracks the counters shown in the progress display.
+// All methods are safe to call on a nil progress, in which case they only
+// perform the non-display side effects (such as printing results).
+type progress struct {
+	mu        sync.Mutex
+	start     time.Time
+	total     int
+	queued    int
+	completed int
+	kinds     map[string]*kindCount
+	rate      float64   // Smoothed jobs completed per second
+	lastTick  time.Time // Time of the last rate sample
+	lastCount int       // Completed count at the last rate sample
+	drawn     bool      // Whether a progress line is currently on screen
+	finished  bool      // Whether finish has been called
+}
+
+// stderrIsTerminal reports whether stderr is attached to a terminal.
+func stderrIsTerminal() bool {
+	fi, err := os.Stderr.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgress creates a progress display.
+func newProgress() *progress {
+	now := time.Now()
+	return &progress{
+		start:    now,
+		lastTick: now,
+		kinds:    make(map[string]*kindCount),
+	}
+}
+
+// setTotal records the total number of jobs that will be queued.
+func (p *progress) setTotal(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = n
+}
+
+// jobQueued records that a job has been sent to the workers.
+func (p *progress) jobQueued() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued++
+}
+
+// jobDone records a completed job.
+func (p *progress) jobDone(j *scanner.Job) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.completed++
+	c, ok := p.kinds[j.Kind]
+	if !ok {
+		c = &kindCount{}
+		p.kinds[j.Kind] = c
+	}
+	if j.Open {
+		c.open++
+	} else {
+		c.closed++
+	}
+}
+
+// printf prints a result line to stdout, clearing and redrawing the progress
+// line around it so the two do not interleave on a terminal.
+func (p *progress) printf(format string, a ...any) {
+	if p == nil {
+		fmt.Printf(format, a...)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Printf(format, a...)
+	if !p.finished {
+		p.draw()
+	}
+}
+
+// run redraws the progress line periodically until the context is canceled.
+func (p *progress) run(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			p.mu.Lock()
+			if p.finished {
+				p.mu.Unlock()
+				return
+			}
+			p.sample(now)
+			p.clear()
+			p.draw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// finish draws the final state of the progress line and moves to a new line.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished {
+		return
+	}
+	p.clear()
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+	p.drawn = false
+	p.finished = true
+}
+
+// sample updates the smoothed completion rate. The caller must hold p.mu.
+func (p *progress) sample(now time.Time) {
+	elapsed := now.Sub(p.lastTick).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(p.completed-p.lastCount) / elapsed
+	if p.rate == 0 {
+		p.rate = current
+	} else {
+		// exponentially weighted moving average to keep the ETA stable
+		p.rate = 0.8*p.rate + 0.2*current
+	}
+	p.lastTick = now
+	p.lastCount = p.completed
+}
+
+// clear erases the progress line if one is drawn. The caller must hold p.mu.
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// draw writes the progress line to stderr. The caller must hold p.mu.
+func (p *progress) draw() {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d done / %d queued / %d total", p.completed, p.queued, p.total)
+
+	kinds := make([]string, 0, len(p.kinds))
+	for k := range p.kinds {
+		kinds = append(kinds, k)
+	}
+	slices.Sort(kinds)
+	for _, k := range kinds {
+		c := p.kinds[k]
+		fmt.Fprintf(&b, " | %s %d open %d closed", k, c.open, c.closed)
+	}
+
+	fmt.Fprintf(&b, " | %.1f/s", p.rate)
+	if remaining := p.total - p.completed; remaining > 0 && p.rate > 0 {
+		eta := time.Duration(float64(remaining) / p.rate * float64(time.Second))
+		fmt.Fprintf(&b, " ETA %s", eta.Round(time.Second))
+	} else {
+		fmt.Fprintf(&b, " %s elapsed", time.Since(p.start).Round(time.Second))
+	}
+	fmt.Fprint(os.Stderr, b.String())
+	p.drawn = true
+}
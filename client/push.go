@@ -0,0 +1,182 @@
+// Package main provides metrics push for the portquiz client.
+// The results of a scan are pushed to a Prometheus Pushgateway so periodic scans can be graphed centrally.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lanrat/portquiz/internal/exposition"
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// pushSeries identifies the jobs of a kind to a destination in pushed metrics.
+type pushSeries struct {
+	target string
+	addr   string // Address tested, only set with -all-addrs
+	source string // Source tested from, only set when more than one source is tested
+	kind   string
+}
+
+// labels formats the series as Prometheus labels, omitting empty ones, followed by any extra labels.
+func (s pushSeries) labels(extra ...string) string {
+	return exposition.Labels(append([]string{"target", s.target, "addr", s.addr, "source", s.source, "kind", s.kind}, extra...)...)
+}
+
+// pushTimings holds the probe timings of the open ports of a series.
+type pushTimings struct {
+	connect, firstByte, total []time.Duration
+}
+
+// pushMetrics returns the results of the completed jobs in the Prometheus text exposition format.
+// For each series it reports the open and closed port counts and the distribution of the probe
+// timings of open ports, along with how long the scan took and whether it was interrupted.
+func pushMetrics(done []*scanner.Job, took time.Duration, interrupted bool, notCompleted int) string {
+	var series []pushSeries
+	openCount := make(map[pushSeries]int)
+	closedCount := make(map[pushSeries]int)
+	timings := make(map[pushSeries]*pushTimings)
+	for _, j := range done {
+		s := pushSeries{target: j.Target, kind: j.Kind}
+		if *allAddrs {
+			s.addr = j.Addr
+		}
+		if len(sc.Sources()) > 1 {
+			s.source = j.Source.String()
+		}
+		if timings[s] == nil {
+			series = append(series, s)
+			timings[s] = &pushTimings{}
+		}
+		if !j.Open {
+			closedCount[s]++
+			continue
+		}
+		openCount[s]++
+		// jobs resumed from a state file have no timing
+		if p, ok := latency(j); ok {
+			timings[s].connect = append(timings[s].connect, p.Connect)
+			timings[s].firstByte = append(timings[s].firstByte, p.FirstByte)
+			timings[s].total = append(timings[s].total, p.Total)
+		}
+	}
+	slices.SortFunc(series, func(a, b pushSeries) int { return strings.Compare(a.labels(), b.labels()) })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP portquiz_client_ports Ports tested by state, open or closed.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_client_ports gauge\n")
+	for _, s := range series {
+		fmt.Fprintf(&b, "portquiz_client_ports{%s} %d\n", s.labels("state", "open"), openCount[s])
+		fmt.Fprintf(&b, "portquiz_client_ports{%s} %d\n", s.labels("state", "closed"), closedCount[s])
+	}
+
+	fmt.Fprintf(&b, "# HELP portquiz_client_probe_seconds Timing of the probes that found ports open, by phase: connect, first_byte or rtt.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_client_probe_seconds summary\n")
+	for _, s := range series {
+		t := timings[s]
+		for _, phase := range []struct {
+			name string
+			d    []time.Duration
+		}{{"connect", t.connect}, {"first_byte", t.firstByte}, {"rtt", t.total}} {
+			if len(phase.d) == 0 {
+				continue
+			}
+			var sum time.Duration
+			for _, d := range phase.d {
+				sum += d
+			}
+			st := newLatencyStats(phase.d)
+			for _, q := range []struct {
+				quantile string
+				d        time.Duration
+			}{{"0", st.min}, {"0.5", st.median}, {"0.95", st.p95}, {"1", st.max}} {
+				fmt.Fprintf(&b, "portquiz_client_probe_seconds{%s} %g\n", s.labels("phase", phase.name, "quantile", q.quantile), q.d.Seconds())
+			}
+			fmt.Fprintf(&b, "portquiz_client_probe_seconds_sum{%s} %g\n", s.labels("phase", phase.name), sum.Seconds())
+			fmt.Fprintf(&b, "portquiz_client_probe_seconds_count{%s} %d\n", s.labels("phase", phase.name), len(phase.d))
+		}
+	}
+
+	fmt.Fprintf(&b, "# HELP portquiz_client_scan_duration_seconds Time the scan took.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_client_scan_duration_seconds gauge\n")
+	fmt.Fprintf(&b, "portquiz_client_scan_duration_seconds %g\n", took.Seconds())
+	interruptedValue := 0
+	if interrupted {
+		interruptedValue = 1
+	}
+	fmt.Fprintf(&b, "# HELP portquiz_client_scan_interrupted Whether the scan was interrupted before every job completed.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_client_scan_interrupted gauge\n")
+	fmt.Fprintf(&b, "portquiz_client_scan_interrupted %d\n", interruptedValue)
+	fmt.Fprintf(&b, "# HELP portquiz_client_jobs_not_completed Jobs not completed because the scan was interrupted.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_client_jobs_not_completed gauge\n")
+	fmt.Fprintf(&b, "portquiz_client_jobs_not_completed %d\n", notCompleted)
+	return b.String()
+}
+
+// pushURL returns the Pushgateway URL of the group given by -push-job and -push-instance,
+// the instance defaulting to the hostname. Label values are base64 encoded as the Pushgateway
+// requires when they contain a slash.
+func pushURL() string {
+	instance := *pushInstance
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	u := strings.TrimSuffix(*pushGateway, "/") + "/metrics"
+	for _, kv := range [][2]string{{"job", *pushJob}, {"instance", instance}} {
+		if kv[1] == "" {
+			continue
+		}
+		if strings.Contains(kv[1], "/") {
+			u += "/" + kv[0] + "@base64/" + base64.RawURLEncoding.EncodeToString([]byte(kv[1]))
+		} else {
+			u += "/" + kv[0] + "/" + url.PathEscape(kv[1])
+		}
+	}
+	return u
+}
+
+// checkPush returns an error if the -push flags are invalid.
+func checkPush() error {
+	if *pushGateway == "" {
+		return nil
+	}
+	u, err := url.Parse(*pushGateway)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid -push %q, must be an http or https URL", *pushGateway)
+	}
+	if *pushJob == "" {
+		return errors.New("-push-job must be set with -push")
+	}
+	return nil
+}
+
+// push replaces the metrics of the -push-job and -push-instance group on the Pushgateway with the results of the scan.
+func push(ctx context.Context, done []*scanner.Job, took time.Duration, interrupted bool, notCompleted int) error {
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	body := pushMetrics(done, took, interrupted, notCompleted)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, pushURL(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
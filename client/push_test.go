@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// pushRequest is a request received by the fake Pushgateway.
+type pushRequest struct {
+	method, path, contentType, body string
+}
+
+// fakePushgateway starts a Pushgateway answering with the status, and returns the channel
+// the requests it receives are sent to.
+func fakePushgateway(t *testing.T, status int) (*httptest.Server, chan pushRequest) {
+	t.Helper()
+	requests := make(chan pushRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- pushRequest{r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, requests
+}
+
+// setPushFlags sets the -push flags and the scanner used by push, restoring them when the test ends.
+func setPushFlags(t *testing.T, gateway, job, instance string) {
+	t.Helper()
+	oldGateway, oldJob, oldInstance, oldSc := *pushGateway, *pushJob, *pushInstance, sc
+	t.Cleanup(func() {
+		*pushGateway, *pushJob, *pushInstance, sc = oldGateway, oldJob, oldInstance, oldSc
+	})
+	*pushGateway, *pushJob, *pushInstance = gateway, job, instance
+	var err error
+	sc, err = scanner.New(scanner.Config{
+		TCP:      true,
+		Password: "portquiz",
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      string
+		instance string
+		path     string
+	}{
+		{"plain", "portquiz", "host-1", "/metrics/job/portquiz/instance/host-1"},
+		{"slash", "portquiz", "dc1/rack2", "/metrics/job/portquiz/instance@base64/ZGMxL3JhY2sy"},
+		{"escaped", "port quiz", "host-1", "/metrics/job/port%20quiz/instance/host-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, requests := fakePushgateway(t, http.StatusOK)
+			setPushFlags(t, srv.URL+"/", tt.job, tt.instance)
+			// a tab is kept as is in the exposition format, unlike Go quoting
+			const target = "quiz\"\\\t.example"
+			const labels = `target="quiz\"\\` + "\t" + `.example",kind="tcp4"`
+			done := []*scanner.Job{
+				{Target: target, Kind: "tcp4", Port: 80, Open: true, Attempts: []scanner.Attempt{
+					{Open: true, Probes: []scanner.Probe{{Outcome: scanner.OutcomeOpen, Total: 20 * time.Millisecond}}},
+				}},
+				{Target: target, Kind: "tcp4", Port: 81},
+			}
+
+			if err := push(context.Background(), done, 2*time.Second, true, 3); err != nil {
+				t.Fatal(err)
+			}
+			r := <-requests
+			if r.method != http.MethodPut || r.path != tt.path {
+				t.Errorf("got %s %s, want PUT %s", r.method, r.path, tt.path)
+			}
+			if !strings.HasPrefix(r.contentType, "text/plain; version=0.0.4") {
+				t.Errorf("got content type %q", r.contentType)
+			}
+			for _, line := range []string{
+				`portquiz_client_ports{` + labels + `,state="open"} 1`,
+				`portquiz_client_ports{` + labels + `,state="closed"} 1`,
+				`portquiz_client_probe_seconds{` + labels + `,phase="rtt",quantile="0.5"} 0.02`,
+				`portquiz_client_probe_seconds_count{` + labels + `,phase="rtt"} 1`,
+				`portquiz_client_scan_duration_seconds 2`,
+				`portquiz_client_scan_interrupted 1`,
+				`portquiz_client_jobs_not_completed 3`,
+			} {
+				if !strings.Contains(r.body, line+"\n") {
+					t.Errorf("body is missing %s:\n%s", line, r.body)
+				}
+			}
+		})
+	}
+}
+
+func TestPushError(t *testing.T) {
+	srv, requests := fakePushgateway(t, http.StatusBadRequest)
+	setPushFlags(t, srv.URL, "portquiz", "host-1")
+	err := push(context.Background(), nil, time.Second, false, 0)
+	<-requests
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("got error %v, want the 400 status", err)
+	}
+}
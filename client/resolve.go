@@ -0,0 +1,53 @@
+// Package main provides target address checks for the portquiz client.
+// It reports targets without addresses to test and names every destination tested.
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// checkAddrs logs every target and kind without an address to test, and returns
+// an error if no target has an address for any kind.
+func checkAddrs() error {
+	usable := false
+	for _, t := range targets {
+		for _, kind := range sc.Kinds() {
+			if len(sc.Addrs(t, kind)) == 0 {
+				slog.Warn("no address for kind, skipping it", "remote", t, "kind", kind)
+				continue
+			}
+			usable = true
+		}
+	}
+	if !usable {
+		return fmt.Errorf("no addresses to test for %s", strings.Join(targets, ", "))
+	}
+	return nil
+}
+
+// destinations returns the name of every target, or every target address with -all-addrs,
+// in the order they are tested. Each is repeated for every source when more than one is tested.
+func destinations() []string {
+	var list []string
+	for _, t := range targets {
+		names := []string{t}
+		if *allAddrs {
+			names = names[:0]
+			for _, ip := range sc.Resolved(t) {
+				names = append(names, t+" "+ip.String())
+			}
+		}
+		for _, n := range names {
+			if len(sc.Sources()) == 1 {
+				list = append(list, n)
+				continue
+			}
+			for _, src := range sc.Sources() {
+				list = append(list, n+" "+src.String())
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,160 @@
+// Package main provides resumable scan state for the portquiz client.
+// It records completed jobs to a file so an interrupted scan can skip them when restarted.
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lanrat/portquiz/scanner"
+)
+
+// state is the active scan state file, nil when -state is not set.
+var state *stateFile
+
+// stateRecord is a single line of the state file describing one completed job.
+// The kinds and password hash identify the scan the job belongs to, and the
+// server is the job's target.
+type stateRecord struct {
+	Server       string `json:"server"`
+	Address      string `json:"address,omitempty"`
+	Source       string `json:"source,omitempty"`
+	Kinds        string `json:"kinds"`
+	PasswordHash string `json:"password_hash"`
+	Kind         string `json:"kind"`
+	Port         int    `json:"port"`
+	Open         bool   `json:"open"`
+}
+
+// stateJob identifies a job within a scan.
+type stateJob struct {
+	server  string
+	address string
+	source  string
+	kind    string
+	port    int
+}
+
+// stateFile appends completed jobs to a file and remembers previously completed jobs
+// for the same scan so they are not tested again.
+type stateFile struct {
+	mu   sync.Mutex
+	f    *os.File
+	scan stateRecord       // Scan identity fields written on every record
+	done map[stateJob]bool // Previously completed jobs and whether they were open
+}
+
+// passwordHash returns a hash of the magic string so the state file does not store it.
+func passwordHash() string {
+	sum := sha256.Sum256([]byte(*magicString))
+	return hex.EncodeToString(sum[:])
+}
+
+// openState loads the completed jobs recorded in path for the current scan and
+// opens the file to append newly completed jobs. The file is created if needed.
+func openState(path string) (*stateFile, error) {
+	s := &stateFile{
+		scan: stateRecord{
+			Kinds:        strings.Join(sc.Kinds(), ","),
+			PasswordHash: passwordHash(),
+		},
+		done: make(map[stateJob]bool),
+	}
+
+	err := s.load(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	s.f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the records in path belonging to the current scan.
+// Lines that cannot be parsed, such as one cut short by a crash, are skipped.
+func (s *stateFile) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r stateRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		if r.Kinds != s.scan.Kinds || r.PasswordHash != s.scan.PasswordHash {
+			continue
+		}
+		s.done[stateJob{r.Server, r.Address, r.Source, r.Kind, r.Port}] = r.Open
+	}
+	return scanner.Err()
+}
+
+// stateAddress returns the job's address to record in the state file.
+// The address is only recorded with -all-addrs, so a target resolving to a
+// different address does not prevent resuming.
+func stateAddress(j *scanner.Job) string {
+	if *allAddrs {
+		return j.Addr
+	}
+	return ""
+}
+
+// lookup returns whether the job was previously completed and if it was open.
+func (s *stateFile) lookup(j *scanner.Job) (open, ok bool) {
+	if s == nil {
+		return false, false
+	}
+	open, ok = s.done[stateJob{j.Target, stateAddress(j), j.Source.String(), j.Kind, j.Port}]
+	return open, ok
+}
+
+// record appends a completed job to the state file.
+// Each record is written immediately so an interrupted scan loses at most the jobs in flight.
+func (s *stateFile) record(j *scanner.Job) error {
+	if s == nil {
+		return nil
+	}
+	r := s.scan
+	r.Server = j.Target
+	r.Address = stateAddress(j)
+	r.Source = j.Source.String()
+	r.Kind = j.Kind
+	r.Port = j.Port
+	r.Open = j.Open
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the state file to disk and closes it.
+func (s *stateFile) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Join(s.f.Sync(), s.f.Close())
+}
@@ -1,4 +1,4 @@
-module portquiz
+module github.com/lanrat/portquiz
 
 go 1.23.0
 
@@ -7,3 +7,5 @@ toolchain go1.23.4
 require golang.org/x/sync v0.16.0
 
 require github.com/coreos/go-iptables v0.8.0
+
+require golang.org/x/sys v0.30.0
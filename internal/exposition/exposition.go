@@ -0,0 +1,24 @@
+// Package exposition provides formatting for the Prometheus text exposition format,
+// shared by the server metrics and the metrics the client pushes.
+package exposition
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelEscaper escapes label values, the format only escapes backslashes, double quotes
+// and line feeds, unlike Go quoting.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Labels formats label names and values given in pairs as the labels of a series,
+// omitting labels with empty values.
+func Labels(kv ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			parts = append(parts, fmt.Sprintf(`%s="%s"`, kv[i], labelEscaper.Replace(kv[i+1])))
+		}
+	}
+	return strings.Join(parts, ",")
+}
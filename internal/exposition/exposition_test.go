@@ -0,0 +1,25 @@
+package exposition
+
+import "testing"
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		kv   []string
+		want string
+	}{
+		{"none", nil, ""},
+		{"pairs", []string{"kind", "tcp4", "state", "open"}, `kind="tcp4",state="open"`},
+		{"empty omitted", []string{"kind", "tcp4", "addr", "", "state", "open"}, `kind="tcp4",state="open"`},
+		{"escaped", []string{"target", "a\\b\"c\nd"}, `target="a\\b\"c\nd"`},
+		// other characters are kept as is, unlike Go quoting
+		{"unicode and tabs", []string{"target", "café\tx"}, "target=\"café\tx\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Labels(tt.kv...); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
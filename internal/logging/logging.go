@@ -0,0 +1,35 @@
+// Package logging provides the structured logging of the portquiz client and server.
+// Logs are written to stderr with log/slog as text or JSON, filtered by level.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Log formats supported by the -log-format flag.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing to stderr in the given format, logging messages at level and above.
+// verbose lowers the level to debug.
+func New(format, level string, verbose bool) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, must be debug, info, warn or error", level)
+	}
+	if verbose {
+		l = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
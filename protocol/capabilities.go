@@ -0,0 +1,85 @@
+// Package protocol provides the capabilities reply of the control channel.
+// The server describes what it supports so the client only tests what can be reached.
+package protocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Size returns the number of ports in the range.
+func (r PortRange) Size() int {
+	return r.End - r.Start + 1
+}
+
+// ParsePortRanges parses a comma separated list of ports and ranges such as "22,8000-8100".
+func ParsePortRanges(s string) ([]PortRange, error) {
+	var ranges []PortRange
+	for _, ps := range strings.Split(s, ",") {
+		if ps == "" {
+			continue
+		}
+		startPort, endPort, isRange := strings.Cut(ps, "-")
+		start, err := strconv.Atoi(startPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", ps)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(endPort)
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q", ps)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q", ps)
+		}
+		ranges = append(ranges, PortRange{start, end})
+	}
+	return ranges, nil
+}
+
+// Capabilities describes what the server supports, returned by the "capabilities" command.
+type Capabilities struct {
+	Version  string      `json:"version"`
+	TCP      bool        `json:"tcp"`
+	UDP      bool        `json:"udp"`
+	Listen   []string    `json:"listen"`
+	Port     uint        `json:"port"`
+	Redirect bool        `json:"redirect"`       // Whether other ports are redirected to the server, false when only its port is reached
+	Excluded []PortRange `json:"excluded_ports"` // Ports that are not redirected to the server
+}
+
+// Supports reports whether the server supports the protocol of the kind.
+// A nil Capabilities supports everything.
+func (c *Capabilities) Supports(kind string) bool {
+	if c == nil {
+		return true
+	}
+	return (strings.HasPrefix(kind, "tcp") && c.TCP) || (strings.HasPrefix(kind, "udp") && c.UDP)
+}
+
+// Excludes reports whether the server does not redirect the port.
+// When the server redirects nothing only its own port is reached, so every other port is excluded.
+// A nil Capabilities excludes nothing.
+func (c *Capabilities) Excludes(port int) bool {
+	if c == nil {
+		return false
+	}
+	if !c.Redirect {
+		return port != int(c.Port)
+	}
+	for _, r := range c.Excluded {
+		if port >= r.Start && port <= r.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+// Package protocol provides the wire protocol shared by the portquiz client and server.
+// A probe is the password, followed by the session ID, if any, and the port probed, and the
+// server echoes the password back. Control commands are lines starting with ControlPrefix sent over TCP.
+package protocol
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// PasswordMismatchReply is sent by the server when it receives data without the password,
+// so the client can tell a wrong password apart from a host that is not a portquiz server.
+var PasswordMismatchReply = []byte("PORTQUIZ PASSWORD MISMATCH")
+
+// ControlPrefix starts every control command, followed by the command name and password.
+var ControlPrefix = []byte("PORTQUIZ-CONTROL ")
+
+// Fields sent after the password in probes.
+var (
+	SessionPrefix = []byte(" session=") // Session ID of the scan
+	PortPrefix    = []byte(" port=")    // Port the client sent the probe to
+)
+
+// MaxSessionLen is the maximum length of a session ID.
+const MaxSessionLen = 64
+
+// ValidSession reports whether id can be used as a session ID:
+// 1 to 64 letters, digits, '.', '_' or '-'.
+func ValidSession(id string) bool {
+	if id == "" || len(id) > MaxSessionLen {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Probe returns the data sent in a probe to the port: the password followed by the session,
+// if any, and the port, if not 0. The port lets the server record hits under the port the client
+// sent to, which it can not always recover after the redirection.
+func Probe(password, session string, port int) []byte {
+	p := []byte(password)
+	if session != "" {
+		p = append(append(p, SessionPrefix...), session...)
+	}
+	if port != 0 {
+		p = strconv.AppendInt(append(p, PortPrefix...), int64(port), 10)
+	}
+	return p
+}
+
+// ProbeInfo holds the fields a client sent after the password in a probe.
+type ProbeInfo struct {
+	Session string // Session ID, empty if none
+	Port    int    // Port the client sent the probe to, 0 if unknown
+}
+
+// ParseProbe returns the fields sent after the password in a probe, leaving those that are
+// missing or invalid empty. The probe must start with the password.
+func ParseProbe(probe []byte, password string) ProbeInfo {
+	var info ProbeInfo
+	rest := probe[len(password):]
+	if len(rest) > 0 && rest[0] != ' ' {
+		return info
+	}
+	for _, field := range strings.Fields(string(rest)) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "session":
+			if ValidSession(value) {
+				info.Session = value
+			}
+		case "port":
+			if port, err := strconv.Atoi(value); err == nil && port > 0 && port <= 65535 {
+				info.Port = port
+			}
+		}
+	}
+	return info
+}
+
+// ControlCommand returns the line sending the control command with the password.
+func ControlCommand(command, password string) []byte {
+	return append(bytes.Clone(ControlPrefix), command+" "+password+"\n"...)
+}
+
+// ParseControlCommand returns the command name and password of a control command line.
+// The line must start with ControlPrefix.
+func ParseControlCommand(line []byte) (command, password string) {
+	rest := strings.TrimRight(string(bytes.TrimPrefix(line, ControlPrefix)), "\r\n")
+	command, password, _ = strings.Cut(rest, " ")
+	return command, password
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import "testing"
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe string
+		want  ProbeInfo
+	}{
+		{"password only", "portquiz", ProbeInfo{}},
+		{"session", "portquiz session=site-a", ProbeInfo{Session: "site-a"}},
+		{"port", "portquiz port=53", ProbeInfo{Port: 53}},
+		{"session and port", "portquiz session=site-a port=443", ProbeInfo{Session: "site-a", Port: 443}},
+		{"any order", "portquiz port=443 session=site-a", ProbeInfo{Session: "site-a", Port: 443}},
+		{"trailing newline", "portquiz session=site-a port=443\r\n", ProbeInfo{Session: "site-a", Port: 443}},
+		{"invalid session", "portquiz session=a/b port=443", ProbeInfo{Port: 443}},
+		{"invalid port", "portquiz session=site-a port=70000", ProbeInfo{Session: "site-a"}},
+		{"not a number", "portquiz port=http", ProbeInfo{}},
+		{"unknown field", "portquiz future=1 port=22", ProbeInfo{Port: 22}},
+		{"no separator", "portquizport=22", ProbeInfo{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseProbe([]byte(tt.probe), "portquiz"); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, want := range []ProbeInfo{{}, {Session: "site-a"}, {Port: 1}, {Session: "s", Port: 65535}} {
+		if got := ParseProbe(Probe("portquiz", want.Session, want.Port), "portquiz"); got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	if got := string(Probe("portquiz", "site-a", 53)); got != "portquiz session=site-a port=53" {
+		t.Errorf("got probe %q", got)
+	}
+}
@@ -0,0 +1,46 @@
+// Package quizserver provides the control channel.
+// It answers control commands sent over TCP with information about the server.
+package quizserver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// Capabilities returns the capabilities of the server.
+func (s *Server) Capabilities() protocol.Capabilities {
+	listen := append([]string{}, s.cfg.Listen...)
+	excluded := s.cfg.Excluded
+	if excluded == nil {
+		excluded = []protocol.PortRange{}
+	}
+	return protocol.Capabilities{
+		Version:  s.cfg.Version,
+		TCP:      s.cfg.TCP,
+		UDP:      s.cfg.UDP,
+		Listen:   listen,
+		Port:     s.cfg.Port,
+		Redirect: s.cfg.Redirect,
+		Excluded: excluded,
+	}
+}
+
+// handleControl answers a control command and returns the reply to send.
+// The command must be followed by the password, otherwise protocol.PasswordMismatchReply is returned.
+func (s *Server) handleControl(data []byte) []byte {
+	command, password := protocol.ParseControlCommand(data)
+	if password != s.cfg.Password {
+		return protocol.PasswordMismatchReply
+	}
+	switch command {
+	case "capabilities":
+		reply, err := json.Marshal(s.Capabilities())
+		if err != nil {
+			return []byte(fmt.Sprintf("error: %s\n", err))
+		}
+		return append(reply, '\n')
+	}
+	return []byte(fmt.Sprintf("error: unknown command %q\n", command))
+}
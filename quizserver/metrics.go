@@ -0,0 +1,209 @@
+// Package quizserver provides Prometheus metrics for the server.
+// Metrics are kept in memory and served in the Prometheus text exposition format.
+package quizserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lanrat/portquiz/internal/exposition"
+)
+
+// durationBuckets are the upper bounds in seconds of the handler duration histogram buckets.
+var durationBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// Results of a request counted by portquiz_requests_total.
+const (
+	resultMatch    = "match"    // The magic string was received and echoed
+	resultMismatch = "mismatch" // Data without the magic string was received
+	resultControl  = "control"  // A control command was received
+	resultEmpty    = "empty"    // The connection closed before any data was received
+	resultIgnored  = "ignored"  // Data was received over TCP when only the control channel is served
+)
+
+// metricLabels identifies a series of a metric, unused labels are empty.
+type metricLabels struct {
+	protocol string
+	listen   string
+	result   string
+	op       string
+}
+
+// String formats the labels in the exposition format, omitting empty labels.
+func (l metricLabels) String() string {
+	return exposition.Labels("protocol", l.protocol, "listen", l.listen, "result", l.result, "op", l.op)
+}
+
+// histogram counts observations in durationBuckets.
+type histogram struct {
+	counts []uint64 // Observations in each bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// observe adds an observation in seconds.
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	if i, _ := slices.BinarySearch(durationBuckets, v); i < len(durationBuckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Metrics records the activity of a Server for Prometheus.
+// All methods are safe to call on a nil Metrics, in which case nothing is recorded.
+type Metrics struct {
+	mu          sync.Mutex
+	connections map[metricLabels]uint64     // TCP connections accepted
+	datagrams   map[metricLabels]uint64     // UDP datagrams received
+	requests    map[metricLabels]uint64     // Requests by result
+	echoed      map[metricLabels]uint64     // Bytes of magic string echoed
+	errors      map[metricLabels]uint64     // Read and write errors
+	active      map[metricLabels]int64      // TCP connections being handled
+	durations   map[metricLabels]*histogram // Time spent handling each request
+}
+
+// NewMetrics returns an empty Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		connections: make(map[metricLabels]uint64),
+		datagrams:   make(map[metricLabels]uint64),
+		requests:    make(map[metricLabels]uint64),
+		echoed:      make(map[metricLabels]uint64),
+		errors:      make(map[metricLabels]uint64),
+		active:      make(map[metricLabels]int64),
+		durations:   make(map[metricLabels]*histogram),
+	}
+}
+
+// connectionOpened counts an accepted TCP connection as received and active.
+func (m *Metrics) connectionOpened(listen string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.connections[metricLabels{listen: listen}]++
+	m.active[metricLabels{listen: listen}]++
+}
+
+// connectionClosed counts a TCP connection as no longer active.
+func (m *Metrics) connectionClosed(listen string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active[metricLabels{listen: listen}]--
+}
+
+// datagramReceived counts a received UDP datagram.
+func (m *Metrics) datagramReceived(listen string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.datagrams[metricLabels{listen: listen}]++
+}
+
+// request records the result of a request, the bytes of magic string echoed and how long it took.
+func (m *Metrics) request(protocol, listen, result string, echoed int, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[metricLabels{protocol: protocol, listen: listen, result: result}]++
+	if echoed > 0 {
+		m.echoed[metricLabels{protocol: protocol, listen: listen}] += uint64(echoed)
+	}
+	l := metricLabels{protocol: protocol}
+	h := m.durations[l]
+	if h == nil {
+		h = &histogram{}
+		m.durations[l] = h
+	}
+	h.observe(took.Seconds())
+}
+
+// ioError counts a failed read or write.
+func (m *Metrics) ioError(protocol, listen, op string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[metricLabels{protocol: protocol, listen: listen, op: op}]++
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	writeCounter(&b, "portquiz_tcp_connections_total", "TCP connections accepted.", m.connections)
+	writeCounter(&b, "portquiz_udp_datagrams_total", "UDP datagrams received.", m.datagrams)
+	writeCounter(&b, "portquiz_requests_total", "Requests handled by result: match, mismatch, control, empty or ignored.", m.requests)
+	writeCounter(&b, "portquiz_echoed_bytes_total", "Bytes of magic string echoed to clients.", m.echoed)
+	writeCounter(&b, "portquiz_io_errors_total", "Failed reads and writes.", m.errors)
+
+	fmt.Fprintf(&b, "# HELP portquiz_tcp_active_connections TCP connections being handled.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_tcp_active_connections gauge\n")
+	for _, l := range sortedLabels(m.active) {
+		fmt.Fprintf(&b, "portquiz_tcp_active_connections{%s} %d\n", l, m.active[l])
+	}
+
+	fmt.Fprintf(&b, "# HELP portquiz_handler_duration_seconds Time spent handling a request.\n")
+	fmt.Fprintf(&b, "# TYPE portquiz_handler_duration_seconds histogram\n")
+	for _, l := range sortedLabels(m.durations) {
+		h := m.durations[l]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "portquiz_handler_duration_seconds_bucket{%s,le=\"%g\"} %d\n", l, le, cumulative)
+		}
+		fmt.Fprintf(&b, "portquiz_handler_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(&b, "portquiz_handler_duration_seconds_sum{%s} %g\n", l, h.sum)
+		fmt.Fprintf(&b, "portquiz_handler_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// writeCounter writes a counter metric with a sample for every series.
+func writeCounter(b *strings.Builder, name, help string, series map[metricLabels]uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s counter\n", name)
+	for _, l := range sortedLabels(series) {
+		fmt.Fprintf(b, "%s{%s} %d\n", name, l, series[l])
+	}
+}
+
+// sortedLabels returns the labels of the series in a stable order.
+func sortedLabels[V any](series map[metricLabels]V) []metricLabels {
+	labels := make([]metricLabels, 0, len(series))
+	for l := range series {
+		labels = append(labels, l)
+	}
+	slices.SortFunc(labels, func(a, b metricLabels) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return labels
+}
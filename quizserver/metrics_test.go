@@ -0,0 +1,22 @@
+package quizserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricLabelEscaping checks label values only have backslashes, double quotes
+// and line feeds escaped, as Go escapes such as \u00e9 are not valid in the exposition format.
+func TestMetricLabelEscaping(t *testing.T) {
+	m := NewMetrics()
+	m.request("tcp", "café \"a\\b\"\n\x01", resultMatch, 0, time.Millisecond)
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `portquiz_requests_total{protocol="tcp",listen="café \"a\\b\"\n` + "\x01" + `",result="match"} 1`
+	if !strings.Contains(b.String(), want+"\n") {
+		t.Errorf("missing %q in:\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package quizserver
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST for IPv4 and IP6T_SO_ORIGINAL_DST for IPv6,
+// which the unix package does not define.
+const soOriginalDst = 80
+
+// originalPort returns the port the client connected to before the iptables
+// redirection, read from conntrack with SO_ORIGINAL_DST. Connections that were
+// not redirected return their local port.
+func originalPort(c *net.TCPConn) int {
+	local := c.LocalAddr().(*net.TCPAddr)
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return local.Port
+	}
+	// the option returns a sockaddr_in or sockaddr_in6 and unix has no getsockopt for them,
+	// so read them into structs at least as large; the port is at the same offset in both
+	var sa []byte
+	cerr := rc.Control(func(fd uintptr) {
+		if local.IP.To4() != nil {
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, soOriginalDst)
+			if err == nil {
+				sa = mreq.Multiaddr[:]
+			}
+			return
+		}
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, soOriginalDst)
+		if err == nil {
+			// Port holds the bytes in network order, put them back as they were in memory
+			sa = binary.NativeEndian.AppendUint16([]byte{0, 0}, info.Addr.Port)
+		}
+	})
+	if cerr != nil || len(sa) < 4 {
+		return local.Port
+	}
+	return int(binary.BigEndian.Uint16(sa[2:4]))
+}
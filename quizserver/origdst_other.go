@@ -0,0 +1,11 @@
+//go:build !linux
+
+package quizserver
+
+import "net"
+
+// originalPort returns the local port of the connection, as the port the client
+// connected to before a redirection is only available on Linux.
+func originalPort(c *net.TCPConn) int {
+	return c.LocalAddr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,85 @@
+// Package quizserver implements a portquiz server.
+// It answers clients that send the password on every port traffic is redirected
+// to it from, so they can tell which ports are reachable.
+package quizserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+	"golang.org/x/sync/errgroup"
+)
+
+// Config configures a Server.
+type Config struct {
+	TCP      bool          // Answer the magic string over TCP, the control channel is always served over TCP
+	UDP      bool          // Answer the magic string over UDP
+	Listen   []string      // IPs to listen on
+	Port     uint          // Port to listen on
+	Password string        // Magic string to echo, must be the same on the client
+	Timeout  time.Duration // Time allowed for each connection, defaults to 10s
+
+	// Reported to clients by the capabilities command.
+	Version  string               // Version of the server
+	Redirect bool                 // Whether traffic to every port is redirected to the server
+	Excluded []protocol.PortRange // Ports that are not redirected to the server
+
+	Logger  *slog.Logger // Logger for every connection at debug level, defaults to slog.Default()
+	Metrics *Metrics     // Metrics to record the server's activity in, nil to disable
+	Stats   *Stats       // Statistics to record the ports each client reached in, nil to disable
+}
+
+// Server answers portquiz clients.
+type Server struct {
+	cfg     Config
+	magic   []byte
+	log     *slog.Logger
+	metrics *Metrics
+	stats   *Stats
+	verbose bool // Whether debug messages are logged, to skip building them for every packet
+}
+
+// New returns a Server for the configuration, filling in defaults for unset fields.
+func New(cfg Config) (*Server, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Password == "" {
+		return nil, errors.New("password must be set")
+	}
+	if len(cfg.Listen) == 0 {
+		return nil, errors.New("no IPs to listen on")
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	return &Server{
+		cfg:     cfg,
+		magic:   []byte(cfg.Password),
+		log:     cfg.Logger,
+		metrics: cfg.Metrics,
+		stats:   cfg.Stats,
+		verbose: cfg.Logger.Enabled(context.Background(), slog.LevelDebug),
+	}, nil
+}
+
+// Run serves clients on every listen IP until the context is canceled or a listener fails.
+// The TCP server always runs to answer the control channel, but only answers the magic
+// string when TCP is set.
+func (s *Server) Run(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	listenPort := strconv.FormatUint(uint64(s.cfg.Port), 10)
+	for _, ip := range s.cfg.Listen {
+		listen := net.JoinHostPort(ip, listenPort)
+		g.Go(func() error { return s.tcpServer(ctx, listen) })
+		if s.cfg.UDP {
+			g.Go(func() error { return s.udpServer(ctx, listen) })
+		}
+	}
+	return g.Wait()
+}
@@ -0,0 +1,129 @@
+// Package quizserver provides scan session reports.
+// Clients may send a session ID after the magic string, and the hits recorded under it
+// give a server-side view of the scan without the client output.
+package quizserver
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SessionReport lists the ports reached during a session.
+type SessionReport struct {
+	Session string           `json:"session"`
+	Clients []string         `json:"clients"` // IP addresses the session's probes came from
+	First   time.Time        `json:"first"`   // Time of the first hit
+	Last    time.Time        `json:"last"`    // Time of the latest hit
+	Reached map[string][]int `json:"reached"` // Ports reached by protocol, sorted
+}
+
+// Sessions returns a report for every session with hits, sorted by the time of their latest hit.
+func (st *Stats) Sessions() []SessionReport {
+	reports := make(map[string]*SessionReport)
+	for _, h := range st.Query(HitFilter{}) {
+		if h.Session == "" {
+			continue
+		}
+		r := reports[h.Session]
+		if r == nil {
+			r = &SessionReport{Session: h.Session, First: h.First, Last: h.Last, Reached: make(map[string][]int)}
+			reports[h.Session] = r
+		}
+		if !slices.Contains(r.Clients, h.Client) {
+			r.Clients = append(r.Clients, h.Client)
+		}
+		if h.First.Before(r.First) {
+			r.First = h.First
+		}
+		if h.Last.After(r.Last) {
+			r.Last = h.Last
+		}
+		if !slices.Contains(r.Reached[h.Protocol], h.Port) {
+			r.Reached[h.Protocol] = append(r.Reached[h.Protocol], h.Port)
+		}
+	}
+	list := make([]SessionReport, 0, len(reports))
+	for _, r := range reports {
+		for _, ports := range r.Reached {
+			slices.Sort(ports)
+		}
+		list = append(list, *r)
+	}
+	slices.SortFunc(list, func(a, b SessionReport) int {
+		return cmp.Or(a.Last.Compare(b.Last), cmp.Compare(a.Session, b.Session))
+	})
+	return list
+}
+
+// Session returns the report of the session, false if it has no hits.
+func (st *Stats) Session(id string) (SessionReport, bool) {
+	for _, r := range st.Sessions() {
+		if r.Session == id {
+			return r, true
+		}
+	}
+	return SessionReport{}, false
+}
+
+// WriteText writes the report as text, with a REACHED line per protocol.
+func (r SessionReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "SESSION %s\n", r.Session)
+	fmt.Fprintf(&b, "CLIENTS %s\n", strings.Join(r.Clients, " "))
+	fmt.Fprintf(&b, "FIRST %s\n", r.First.Format(time.RFC3339))
+	fmt.Fprintf(&b, "LAST %s\n", r.Last.Format(time.RFC3339))
+	protocols := make([]string, 0, len(r.Reached))
+	for p := range r.Reached {
+		protocols = append(protocols, p)
+	}
+	slices.Sort(protocols)
+	for _, p := range protocols {
+		ports := make([]string, len(r.Reached[p]))
+		for i, port := range r.Reached[p] {
+			ports[i] = fmt.Sprint(port)
+		}
+		fmt.Fprintf(&b, "REACHED %s %d %s\n", p, len(ports), strings.Join(ports, ","))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeSessions answers with the reports of every session as a JSON document.
+func (st *Stats) ServeSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		Sessions []SessionReport `json:"sessions"`
+	}{st.Sessions()})
+}
+
+// ServeSession answers with the report of the session given by the id path value,
+// as JSON or as text with the format=text query parameter.
+func (st *Stats) ServeSession(w http.ResponseWriter, r *http.Request) {
+	report, ok := st.Session(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, report)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = report.WriteText(w)
+	default:
+		http.Error(w, "unknown format, must be json or text", http.StatusBadRequest)
+	}
+}
+
+// writeJSON writes v as an indented JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
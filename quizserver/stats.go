@@ -0,0 +1,267 @@
+// Package quizserver provides per-port hit statistics.
+// Successful requests are aggregated by client IP, protocol and original destination port,
+// so a scan can be verified from the server side when the client output is unavailable.
+package quizserver
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Hit aggregates the successful requests of a client to a port.
+type Hit struct {
+	Client   string    `json:"client"`            // IP address of the client
+	Session  string    `json:"session,omitempty"` // Session ID the client sent, empty if none
+	Protocol string    `json:"protocol"`          // "tcp" or "udp"
+	Port     int       `json:"port"`              // Port the client sent to before the redirection
+	Count    uint64    `json:"count"`             // Number of successful requests since First
+	First    time.Time `json:"first"`             // Time of the first successful request
+	Last     time.Time `json:"last"`              // Time of the latest successful request
+}
+
+// hitKey identifies the Hit of a client to a port.
+type hitKey struct {
+	client   string
+	session  string
+	protocol string
+	port     int
+}
+
+// HitFilter selects hits in Stats.Query, empty fields match any.
+type HitFilter struct {
+	Client   string    // IP address of the client
+	Session  string    // Session ID
+	Protocol string    // "tcp" or "udp"
+	Since    time.Time // Hits last seen before this time are not included
+}
+
+// matches reports whether the hit is selected by the filter.
+func (f HitFilter) matches(h *Hit) bool {
+	return (f.Client == "" || h.Client == f.Client) &&
+		(f.Session == "" || h.Session == f.Session) &&
+		(f.Protocol == "" || h.Protocol == f.Protocol) &&
+		!h.Last.Before(f.Since)
+}
+
+// statsDocument is the JSON document returned by the stats endpoint and stored in the stats file.
+type statsDocument struct {
+	Since *time.Time `json:"since,omitempty"` // Hits last seen before this time are not included
+	Hits  []Hit      `json:"hits"`
+}
+
+// maxClientSessions is the number of sessions kept per client IP, so a client sending
+// a new session ID with every probe cannot fill the store.
+const maxClientSessions = 16
+
+// Stats stores the hits of clients in memory.
+// All methods are safe to call on a nil Stats, in which case nothing is recorded.
+type Stats struct {
+	mu        sync.Mutex
+	retention time.Duration
+	max       int
+	hits      map[hitKey]*Hit
+	sessions  map[string]map[string]int // Number of hits by session of every client
+	pruned    time.Time                 // Time of the latest prune because the store was full
+}
+
+// NewStats returns an empty Stats forgetting hits not seen for longer than retention,
+// or never forgetting them if retention is 0. At most max hits are kept, or any number if
+// max is 0; once full, requests to new ports are not recorded until older hits are forgotten.
+func NewStats(retention time.Duration, max int) *Stats {
+	return &Stats{
+		retention: retention,
+		max:       max,
+		hits:      make(map[hitKey]*Hit),
+		sessions:  make(map[string]map[string]int),
+	}
+}
+
+// record counts a successful request of the client to the port in the session, empty for none.
+func (st *Stats) record(client net.IP, session, protocol string, port int) {
+	if st == nil {
+		return
+	}
+	now := time.Now()
+	k := hitKey{client: client.String(), session: session, protocol: protocol, port: port}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	h := st.hits[k]
+	if h == nil {
+		h = &Hit{Client: k.client, Session: session, Protocol: protocol, Port: port, First: now}
+		if !st.add(k, h) {
+			return
+		}
+	}
+	h.Count++
+	h.Last = now
+}
+
+// add stores the hit of a new key, false if the store or the client's sessions are full.
+// The lock must be held.
+func (st *Stats) add(k hitKey, h *Hit) bool {
+	if st.max > 0 && len(st.hits) >= st.max && time.Since(st.pruned) > time.Minute {
+		// pruning is a walk of the whole store, do it at most once a minute while full
+		st.pruned = time.Now()
+		st.prune()
+	}
+	if st.max > 0 && len(st.hits) >= st.max {
+		return false
+	}
+	sessions := st.sessions[k.client]
+	if k.session != "" && sessions[k.session] == 0 && len(sessions) >= maxClientSessions {
+		return false
+	}
+	if sessions == nil {
+		sessions = make(map[string]int)
+		st.sessions[k.client] = sessions
+	}
+	sessions[k.session]++
+	st.hits[k] = h
+	return true
+}
+
+// remove forgets the hit of the key. The lock must be held.
+func (st *Stats) remove(k hitKey) {
+	delete(st.hits, k)
+	sessions := st.sessions[k.client]
+	if sessions[k.session]--; sessions[k.session] <= 0 {
+		delete(sessions, k.session)
+	}
+	if len(sessions) == 0 {
+		delete(st.sessions, k.client)
+	}
+}
+
+// prune forgets the hits not seen within the retention. The lock must be held.
+func (st *Stats) prune() {
+	if st.retention <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-st.retention)
+	for k, h := range st.hits {
+		if h.Last.Before(cutoff) {
+			st.remove(k)
+		}
+	}
+}
+
+// Query returns the hits selected by the filter, sorted by client, session, protocol and port.
+func (st *Stats) Query(f HitFilter) []Hit {
+	hits := []Hit{}
+	if st == nil {
+		return hits
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.prune()
+	for _, h := range st.hits {
+		if f.matches(h) {
+			hits = append(hits, *h)
+		}
+	}
+	slices.SortFunc(hits, func(a, b Hit) int {
+		return cmp.Or(cmp.Compare(a.Client, b.Client), cmp.Compare(a.Session, b.Session),
+			cmp.Compare(a.Protocol, b.Protocol), cmp.Compare(a.Port, b.Port))
+	})
+	return hits
+}
+
+// Load adds the hits stored in the file at path by Save. A missing file is not an error.
+func (st *Stats) Load(path string) error {
+	if st == nil {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc statsDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, h := range doc.Hits {
+		k := hitKey{client: h.Client, session: h.Session, protocol: h.Protocol, port: h.Port}
+		old := st.hits[k]
+		if old == nil {
+			st.add(k, &h)
+			continue
+		}
+		old.Count += h.Count
+		if h.First.Before(old.First) {
+			old.First = h.First
+		}
+		if h.Last.After(old.Last) {
+			old.Last = h.Last
+		}
+	}
+	st.prune()
+	return nil
+}
+
+// Save stores every hit in the file at path, replacing it atomically.
+func (st *Stats) Save(path string) error {
+	if st == nil {
+		return nil
+	}
+	doc := statsDocument{Hits: st.Query(HitFilter{})}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ServeHTTP answers queries for the hits as a JSON document.
+// The client, session and protocol query parameters filter the hits, and since is how far back
+// to look as a duration such as 1h, defaulting to an hour.
+func (st *Stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	since := time.Hour
+	if s := q.Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid since duration", http.StatusBadRequest)
+			return
+		}
+		since = d
+	}
+	client := q.Get("client")
+	if client != "" {
+		ip := net.ParseIP(client)
+		if ip == nil {
+			http.Error(w, "invalid client IP", http.StatusBadRequest)
+			return
+		}
+		client = ip.String()
+	}
+	f := HitFilter{Client: client, Session: q.Get("session"), Protocol: q.Get("protocol"), Since: time.Now().Add(-since)}
+	writeJSON(w, statsDocument{Since: &f.Since, Hits: st.Query(f)})
+}
@@ -0,0 +1,88 @@
+package quizserver
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsSessionLimit(t *testing.T) {
+	st := NewStats(0, 0)
+	client, other := net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)
+	for i := range 2 * maxClientSessions {
+		st.record(client, fmt.Sprintf("s%d", i), "tcp", 80)
+	}
+	// hits without a session and to more ports of a kept session are still recorded
+	st.record(client, "", "tcp", 80)
+	st.record(client, "s0", "tcp", 443)
+	st.record(other, "s0", "tcp", 80)
+
+	if n := len(st.Query(HitFilter{Client: client.String()})); n != maxClientSessions+2 {
+		t.Errorf("got %d hits of the client, want %d", n, maxClientSessions+2)
+	}
+	if n := len(st.Sessions()); n != maxClientSessions {
+		t.Errorf("got %d sessions, want %d", n, maxClientSessions)
+	}
+	if _, ok := st.Session(fmt.Sprintf("s%d", maxClientSessions)); ok {
+		t.Errorf("session over the limit recorded")
+	}
+	if n := len(st.Query(HitFilter{Client: other.String()})); n != 1 {
+		t.Errorf("got %d hits of another client, want 1", n)
+	}
+}
+
+func TestStatsMaxHits(t *testing.T) {
+	const max = 10
+	st := NewStats(time.Hour, max)
+	client := net.IPv4(192, 0, 2, 1)
+	for port := range 2 * max {
+		st.record(client, "", "tcp", port)
+	}
+	hits := st.Query(HitFilter{})
+	if len(hits) != max {
+		t.Fatalf("got %d hits, want %d", len(hits), max)
+	}
+	// ports already recorded keep counting once full
+	st.record(client, "", "tcp", 0)
+	if h := st.Query(HitFilter{})[0]; h.Port != 0 || h.Count != 2 {
+		t.Errorf("got port %d count %d, want port 0 count 2", h.Port, h.Count)
+	}
+
+	// hits forgotten after the retention make room for new ones
+	st.mu.Lock()
+	for _, h := range st.hits {
+		h.Last = h.Last.Add(-2 * time.Hour)
+	}
+	st.pruned = time.Time{}
+	st.mu.Unlock()
+	st.record(client, "", "tcp", 1000)
+	if hits := st.Query(HitFilter{}); len(hits) != 1 || hits[0].Port != 1000 {
+		t.Errorf("got %v, want only port 1000 after the others expired", hits)
+	}
+}
+
+func TestStatsLoadLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	saved := NewStats(0, 0)
+	client := net.IPv4(192, 0, 2, 1)
+	for i := range 2 * maxClientSessions {
+		saved.record(net.IPv4(192, 0, 2, byte(10+i)), "", "tcp", 80)
+		saved.record(client, fmt.Sprintf("s%d", i), "tcp", 80)
+	}
+	if err := saved.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	st := NewStats(0, 3*maxClientSessions)
+	if err := st.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(st.Query(HitFilter{})); n != 3*maxClientSessions {
+		t.Errorf("got %d hits, want %d", n, 3*maxClientSessions)
+	}
+	if n := len(st.Query(HitFilter{Client: client.String()})); n != maxClientSessions {
+		t.Errorf("got %d hits of the client, want %d", n, maxClientSessions)
+	}
+}
@@ -0,0 +1,152 @@
+// Package quizserver provides TCP server functionality.
+// It handles incoming TCP connections and responds with the magic string when detected.
+package quizserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// tcpServer starts a TCP server on the specified address and handles incoming connections.
+// It accepts connections in a loop and spawns goroutines to handle each connection.
+func (s *Server) tcpServer(ctx context.Context, listenAddr string) error {
+	addr, err := net.ResolveTCPAddr("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.ListenTCP("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.log.Info("starting TCP server", "listen", listenAddr)
+	defer func() {
+		if err := l.Close(); err != nil {
+			s.log.Debug("TCP listener close error", "listen", listenAddr, "error", err)
+		}
+	}()
+
+	// Start a goroutine to handle context cancellation
+	go func() {
+		<-ctx.Done()
+		if err := l.Close(); err != nil {
+			s.log.Warn("TCP listener close on context cancel error", "listen", listenAddr, "error", err)
+		}
+	}()
+
+	for {
+		// Check for cancellation before potentially blocking call
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		c, err := l.AcceptTCP()
+		if err != nil {
+			// Check if context was cancelled
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			return err
+		}
+		go s.handleTCPConnection(c, addr.IP.String())
+	}
+}
+
+// handleTCPConnection processes a single TCP connection.
+// It reads data from the connection, checks for a control command or the magic string,
+// and responds accordingly. listen is the IP the connection was accepted on.
+func (s *Server) handleTCPConnection(c *net.TCPConn, listen string) {
+	start := time.Now()
+	s.metrics.connectionOpened(listen)
+	result, echoed := resultEmpty, 0
+	defer func() {
+		s.metrics.request("tcp", listen, result, echoed, time.Since(start))
+		s.metrics.connectionClosed(listen)
+	}()
+
+	log := s.log.With("kind", "tcp", "remote", c.RemoteAddr().String())
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Debug("TCP connection close error", "error", err)
+		}
+	}()
+	if err := c.SetNoDelay(true); err != nil {
+		log.Debug("TCP SetNoDelay warning", "error", err)
+	}
+	if err := c.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		log.Debug("TCP SetDeadline warning", "error", err)
+	}
+
+	log.Debug("serving")
+
+	buffer := make([]byte, 256)
+
+	n, err := c.Read(buffer)
+	if err != nil {
+		log.Debug("TCP read error", "error", err)
+		if !errors.Is(err, io.EOF) {
+			s.metrics.ioError("tcp", listen, "read")
+		}
+		if n == 0 {
+			return
+		}
+		// data received before the error is still answered
+	}
+	log.Debug("got data", "data", string(buffer[:n]))
+	var reply []byte
+	if bytes.HasPrefix(buffer[:n], protocol.ControlPrefix) {
+		log.Debug("CONTROL")
+		result, reply = resultControl, s.handleControl(buffer[:n])
+	} else if !s.cfg.TCP {
+		// only the control channel is served over TCP
+		result = resultIgnored
+		return
+	} else if bytes.HasPrefix(buffer[:n], s.magic) {
+		log.Debug("PORTQUIZ")
+		result, reply = resultMatch, s.magic
+	} else if n > 0 {
+		// let the client know it reached a portquiz server with the wrong password
+		result, reply = resultMismatch, protocol.PasswordMismatchReply
+	}
+	if reply == nil {
+		return
+	}
+	written, err := c.Write(reply)
+	if err != nil {
+		log.Debug("TCP write error", "error", err)
+		s.metrics.ioError("tcp", listen, "write")
+	}
+	if result == resultMatch {
+		echoed = written
+		if err == nil && s.stats != nil {
+			info := protocol.ParseProbe(buffer[:n], s.cfg.Password)
+			s.stats.record(c.RemoteAddr().(*net.TCPAddr).IP, info.Session, "tcp", hitPort(c, info.Port, log))
+		}
+	}
+}
+
+// hitPort returns the port a TCP probe is recorded under: the port the client sent to, or the
+// original destination port when the client did not say. The two are cross-checked when the
+// connection was redirected, and the client's port is kept as it is the one scanned.
+func hitPort(c *net.TCPConn, sent int, log *slog.Logger) int {
+	orig := originalPort(c)
+	if sent == 0 {
+		return orig
+	}
+	if orig != c.LocalAddr().(*net.TCPAddr).Port && orig != sent {
+		// a NAT in front of the server translated the port
+		log.Debug("probe port differs from the original destination port", "port", sent, "original", orig)
+	}
+	return sent
+}
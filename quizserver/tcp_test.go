@@ -0,0 +1,128 @@
+package quizserver
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+const testPassword = "portquiz"
+
+// newTestServer returns a TCP Server logging at the level with its own Metrics.
+func newTestServer(t *testing.T, level slog.Level) *Server {
+	t.Helper()
+	s, err := New(Config{
+		TCP:      true,
+		Listen:   []string{"127.0.0.1"},
+		Password: testPassword,
+		Timeout:  300 * time.Millisecond,
+		Redirect: true,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: level})),
+		Metrics:  NewMetrics(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// serveOne has the server handle a single connection made by client,
+// and returns what the client read back once the server is done with it.
+func serveOne(t *testing.T, s *Server, client func(c *net.TCPConn)) []byte {
+	t.Helper()
+	l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		c, err := l.AcceptTCP()
+		if err != nil {
+			return
+		}
+		s.handleTCPConnection(c, "127.0.0.1")
+	}()
+	c, err := net.DialTCP("tcp4", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client(c)
+	<-handled
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	reply, _ := io.ReadAll(c)
+	return reply
+}
+
+func TestHandleTCPConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		client func(c *net.TCPConn)
+		reply  string
+		result string
+	}{
+		{"password", func(c *net.TCPConn) { c.Write([]byte(testPassword)) }, testPassword, resultMatch},
+		{"password then close", func(c *net.TCPConn) {
+			c.Write([]byte(testPassword))
+			c.CloseWrite()
+		}, testPassword, resultMatch},
+		{"session", func(c *net.TCPConn) { c.Write(protocol.Probe(testPassword, "site-a", 80)) }, testPassword, resultMatch},
+		{"wrong password", func(c *net.TCPConn) { c.Write([]byte("nope")) }, string(protocol.PasswordMismatchReply), resultMismatch},
+		{"half-close", func(c *net.TCPConn) { c.CloseWrite() }, "", resultEmpty},
+		{"stall", func(c *net.TCPConn) {}, "", resultEmpty},
+		{"control", func(c *net.TCPConn) {
+			c.Write(protocol.ControlCommand("capabilities", "nope"))
+		}, string(protocol.PasswordMismatchReply), resultControl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+				s := newTestServer(t, level)
+				reply := serveOne(t, s, tt.client)
+				if string(reply) != tt.reply {
+					t.Errorf("%s logging: got reply %q, want %q", level, reply, tt.reply)
+				}
+				l := metricLabels{protocol: "tcp", listen: "127.0.0.1", result: tt.result}
+				if n := s.metrics.requests[l]; n != 1 || len(s.metrics.requests) != 1 {
+					t.Errorf("%s logging: got requests %v, want 1 %s", level, s.metrics.requests, tt.result)
+				}
+			}
+		})
+	}
+}
+
+func TestTCPHitPort(t *testing.T) {
+	tests := []struct {
+		name  string
+		port  int
+		local bool // whether the hit is recorded under the local port
+	}{
+		{"probe port", 8080, false},
+		{"no probe port", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, slog.LevelInfo)
+			s.stats = NewStats(0, 0)
+			local := 0
+			serveOne(t, s, func(c *net.TCPConn) {
+				local = c.RemoteAddr().(*net.TCPAddr).Port
+				c.Write(protocol.Probe(testPassword, "site-a", tt.port))
+			})
+			want := tt.port
+			if tt.local {
+				want = local
+			}
+			hits := s.stats.Query(HitFilter{})
+			if len(hits) != 1 || hits[0].Port != want || hits[0].Session != "site-a" || hits[0].Protocol != "tcp" {
+				t.Errorf("got hits %+v, want a site-a tcp hit on port %d", hits, want)
+			}
+		})
+	}
+}
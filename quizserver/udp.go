@@ -0,0 +1,102 @@
+// Package quizserver provides UDP server functionality.
+// It handles incoming UDP packets and responds with the magic string when detected.
+package quizserver
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// udpServer starts a UDP server on the specified address and handles incoming packets.
+// It reads packets in a loop and responds to those containing the magic string.
+func (s *Server) udpServer(ctx context.Context, listenAddr string) error {
+	s.log.Info("starting UDP server", "listen", listenAddr)
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := l.Close(); err != nil {
+			s.log.Debug("UDP listener close error", "listen", listenAddr, "error", err)
+		}
+	}()
+	if err := l.SetReadBuffer(len(s.magic) * 2); err != nil {
+		s.log.Warn("UDP SetReadBuffer error", "listen", listenAddr, "error", err)
+	}
+
+	buffer := make([]byte, 256)
+	listen := addr.IP.String()
+
+	// Start a goroutine to handle context cancellation
+	go func() {
+		<-ctx.Done()
+		if err := l.Close(); err != nil {
+			s.log.Warn("UDP listener close on context cancel error", "listen", listenAddr, "error", err)
+		}
+	}()
+
+	for {
+		// Check for cancellation before potentially blocking call
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		n, remoteAddr, err := l.ReadFromUDP(buffer)
+		if err != nil {
+			// Check if context was cancelled
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			s.log.Debug("UDP read error", "kind", "udp", "listen", listenAddr, "error", err)
+			s.metrics.ioError("udp", listen, "read")
+			continue
+		}
+		start := time.Now()
+		s.metrics.datagramReceived(listen)
+		var log *slog.Logger
+		if s.verbose {
+			log = s.log.With("kind", "udp", "remote", remoteAddr.String())
+			log.Debug("got data", "len", n, "data", string(buffer[:n]))
+		}
+		if bytes.HasPrefix(buffer[:n], s.magic) {
+			if s.verbose {
+				log.Debug("PORTQUIZ")
+			}
+			written, err := l.WriteToUDP(buffer[:n], remoteAddr)
+			if err != nil {
+				s.log.Debug("UDP write error", "kind", "udp", "remote", remoteAddr.String(), "error", err)
+				s.metrics.ioError("udp", listen, "write")
+			} else if info := protocol.ParseProbe(buffer[:n], s.cfg.Password); info.Port != 0 {
+				// the original port of redirected datagrams is not available, so only probes
+				// saying which port they were sent to are recorded
+				s.stats.record(remoteAddr.IP, info.Session, "udp", info.Port)
+			}
+			s.metrics.request("udp", listen, resultMatch, written, time.Since(start))
+		} else {
+			if n >= len(protocol.PasswordMismatchReply) {
+				// let the client know it reached a portquiz server with the wrong password,
+				// never replying with more data than was received to avoid amplification
+				_, err = l.WriteToUDP(protocol.PasswordMismatchReply, remoteAddr)
+				if err != nil {
+					s.log.Debug("UDP write error", "kind", "udp", "remote", remoteAddr.String(), "error", err)
+					s.metrics.ioError("udp", listen, "write")
+				}
+			}
+			s.metrics.request("udp", listen, resultMismatch, 0, time.Since(start))
+		}
+	}
+}
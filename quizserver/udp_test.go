@@ -0,0 +1,79 @@
+package quizserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+func TestUDPHits(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenAddr := pc.LocalAddr().String()
+	pc.Close()
+
+	stats := NewStats(0, 0)
+	s, err := New(Config{
+		UDP:      true,
+		Listen:   []string{"127.0.0.1"},
+		Password: testPassword,
+		Timeout:  300 * time.Millisecond,
+		Redirect: true,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Stats:    stats,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.udpServer(ctx, listenAddr)
+
+	c, err := net.Dial("udp4", listenAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for _, probe := range [][]byte{
+		protocol.Probe(testPassword, "site-a", 53),
+		protocol.Probe(testPassword, "", 123),
+		// probes without the port can not be recorded under the port they were sent to
+		protocol.Probe(testPassword, "site-a", 0),
+	} {
+		// retry as the server may not be listening yet
+		var reply []byte
+		for range 20 {
+			c.Write(probe)
+			c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			buf := make([]byte, 256)
+			if n, err := c.Read(buf); err == nil {
+				reply = buf[:n]
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if string(reply) != string(probe) {
+			t.Fatalf("got reply %q to %q", reply, probe)
+		}
+	}
+
+	hits := stats.Query(HitFilter{})
+	if len(hits) != 2 {
+		t.Fatalf("got %d hits, want 2: %v", len(hits), hits)
+	}
+	for i, want := range []Hit{{Session: "", Port: 123}, {Session: "site-a", Port: 53}} {
+		if h := hits[i]; h.Client != "127.0.0.1" || h.Protocol != "udp" || h.Session != want.Session || h.Port != want.Port {
+			t.Errorf("hit %d: got %+v, want session %q port %d", i, h, want.Session, want.Port)
+		}
+	}
+	if r, ok := stats.Session("site-a"); !ok || len(r.Reached["udp"]) != 1 || r.Reached["udp"][0] != 53 {
+		t.Errorf("got session report %+v, want udp port 53 reached", r)
+	}
+}
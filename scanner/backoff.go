@@ -0,0 +1,61 @@
+// Package scanner provides retry backoff functionality.
+// It computes exponential delays with jitter between probe attempts.
+package scanner
+
+import (
+	"context"
+	"math/rand/v2"
+	"strings"
+	"time"
+)
+
+// retries returns the number of attempts to make for a job of the given kind.
+// The per-protocol counts override the global retry count when set.
+func (s *Scanner) retries(kind string) uint {
+	switch {
+	case strings.HasPrefix(kind, "tcp") && s.cfg.RetryTCP > 0:
+		return s.cfg.RetryTCP
+	case strings.HasPrefix(kind, "udp") && s.cfg.RetryUDP > 0:
+		return s.cfg.RetryUDP
+	}
+	return s.cfg.Retry
+}
+
+// backoffDelay returns the delay to wait before the given retry (1 for the first retry).
+// The delay doubles for each retry up to BackoffMax, and Jitter controls the
+// fraction of the delay that is randomized to avoid synchronized retries.
+func (s *Scanner) backoffDelay(retryNum uint) time.Duration {
+	if s.cfg.Backoff <= 0 || retryNum == 0 {
+		return 0
+	}
+	d := s.cfg.Backoff
+	for i := uint(1); i < retryNum && (s.cfg.BackoffMax <= 0 || d < s.cfg.BackoffMax); i++ {
+		d *= 2
+	}
+	if s.cfg.BackoffMax > 0 && d > s.cfg.BackoffMax {
+		d = s.cfg.BackoffMax
+	}
+
+	j := min(max(s.cfg.Jitter, 0), 1)
+	spread := time.Duration(float64(d) * j)
+	if spread <= 0 {
+		return d
+	}
+	return d - spread + rand.N(spread+1)
+}
+
+// sleepContext waits for the given duration or until the context is canceled.
+// It returns false if the context was canceled before the duration elapsed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,81 @@
+// Package scanner provides the control channel client.
+// It asks the server for its capabilities before scanning.
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// maxControlReply limits how much of a control reply is read.
+const maxControlReply = 64 * 1024
+
+// QueryCapabilities asks the target's server for its capabilities on the first control port that answers.
+// Like Preflight, the error wraps ErrUnreachable, ErrNotPortquiz or ErrPasswordMismatch when the
+// server could not be reached, is not a portquiz server or rejected the password.
+// The first source is used.
+func (s *Scanner) QueryCapabilities(ctx context.Context, target string, ports []int) (*protocol.Capabilities, error) {
+	var err error
+	for _, port := range ports {
+		caps, perr := s.queryCapabilitiesPort(ctx, target, port)
+		if perr == nil {
+			return caps, nil
+		}
+		// a rejected password shows the server was found, so it is reported over other failures
+		if !errors.Is(err, ErrPasswordMismatch) {
+			err = perr
+		}
+	}
+	if err == nil {
+		err = errors.New("no control ports")
+	}
+	return nil, err
+}
+
+// queryCapabilitiesPort sends the capabilities command to a single port over TCP.
+func (s *Scanner) queryCapabilitiesPort(ctx context.Context, target string, port int) (*protocol.Capabilities, error) {
+	network := "tcp" + s.versions()[0]
+	conn, err := s.dialTCP(ctx, s.cfg.Sources[0], network, net.JoinHostPort(target, strconv.Itoa(port)))
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, fmt.Errorf("%w: %s refused control port %d", ErrNotPortquiz, target, port)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not reach %s on control port %d: %w", ErrUnreachable, target, port, err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			s.log.Debug("TCP connection close error", "remote", target, "port", port, "error", err)
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		s.log.Debug("TCP SetDeadline warning", "remote", target, "port", port, "error", err)
+	}
+
+	if _, err := conn.Write(protocol.ControlCommand("capabilities", s.cfg.Password)); err != nil {
+		return nil, err
+	}
+	// the server closes the connection after replying
+	reply, err := io.ReadAll(io.LimitReader(conn, maxControlReply))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s accepted control port %d but did not reply: %w", ErrNotPortquiz, target, port, err)
+	}
+	if bytes.HasPrefix(reply, protocol.PasswordMismatchReply) {
+		return nil, fmt.Errorf("%w: portquiz server %s rejected the password on control port %d",
+			ErrPasswordMismatch, target, port)
+	}
+	var caps protocol.Capabilities
+	if err := json.Unmarshal(reply, &caps); err != nil {
+		return nil, fmt.Errorf("%w: unexpected capabilities reply from %s on control port %d: %w", ErrNotPortquiz, target, port, err)
+	}
+	return &caps, nil
+}
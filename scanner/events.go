@@ -0,0 +1,91 @@
+// Package scanner provides the scan event stream.
+// A scan reports its progress as typed events so embedding programs can follow
+// the same results the client prints.
+package scanner
+
+import (
+	"context"
+	"sync"
+)
+
+// EventType identifies what happened in a scan.
+type EventType int
+
+const (
+	EventJobQueued   EventType = iota // Job was queued to be tested, or to be returned when resumed
+	EventJobStarted                   // A worker started testing Job
+	EventAttemptDone                  // An attempt of Job finished, Attempt is its outcome
+	EventJobDone                      // Job is complete, resumed jobs are done without starting
+	EventScanDone                     // The scan is over, Jobs holds the completed jobs, Pending the rest and Err why it stopped
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventJobQueued:
+		return "job-queued"
+	case EventJobStarted:
+		return "job-started"
+	case EventAttemptDone:
+		return "attempt-done"
+	case EventJobDone:
+		return "job-done"
+	case EventScanDone:
+		return "scan-done"
+	}
+	return "unknown"
+}
+
+// Event is something that happened in a scan.
+// The events of a job are sent in order: EventJobQueued first, then EventJobStarted and
+// EventAttemptDone unless it was resumed, and EventJobDone last. Events of different jobs interleave.
+// The results of Job (Open and Attempts) are only final once its EventJobDone is sent.
+type Event struct {
+	Type    EventType
+	Job     *Job    // Job the event is about, nil for EventScanDone
+	Attempt Attempt // Outcome of the attempt for EventAttemptDone
+	Jobs    []*Job  // Completed jobs for EventScanDone
+	Pending []*Job  // Jobs not completed because the scan stopped early, for EventScanDone
+	Err     error   // Error that stopped the scan for EventScanDone, nil when it completed or was canceled
+}
+
+// Handler is called with every event of a scan, one at a time.
+// Returning an error aborts the scan.
+type Handler func(Event) error
+
+// syncHandler returns a Handler that calls h with one event at a time,
+// or a Handler that does nothing if h is nil.
+func syncHandler(h Handler) Handler {
+	if h == nil {
+		return func(Event) error { return nil }
+	}
+	var mu sync.Mutex
+	return func(e Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return h(e)
+	}
+}
+
+// Stream tests the jobs like Run and returns a channel of its events.
+// The channel is closed after the EventScanDone event. Events are dropped once
+// the context is canceled, but the caller must keep receiving until the channel
+// is closed to get the EventScanDone event with the jobs completed so far.
+func (s *Scanner) Stream(ctx context.Context, list []*Job) <-chan Event {
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		s.Run(ctx, list, func(e Event) error {
+			if e.Type == EventScanDone {
+				events <- e
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+			case events <- e:
+			}
+			return nil
+		})
+	}()
+	return events
+}
@@ -0,0 +1,251 @@
+// Package scanner provides job management and worker functionality.
+// It handles the creation, distribution, and processing of port testing jobs.
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Job is a single port testing task and its result.
+type Job struct {
+	Target   string    // Server host to test
+	Addr     string    // IP address of the target to test, or its host name when resolved by the proxy
+	Source   Source    // Local source to send probes from
+	Kind     string    // Protocol and IP version (e.g., "tcp4", "udp6")
+	Port     int       // Port number to test
+	Open     bool      // Whether the port was found to be open
+	Attempts []Attempt // Outcome of each attempt made, in order
+	Resumed  bool      // Whether the result was known before scanning instead of tested
+}
+
+// Attempt records the outcome of a single try of a job.
+type Attempt struct {
+	Open   bool    // Whether the port was found to be open on this attempt
+	Probes []Probe // Timing of each probe made during this attempt
+}
+
+// Mismatch reports whether any probe of the job got a response without the magic string.
+func (j *Job) Mismatch() bool {
+	for _, a := range j.Attempts {
+		for _, p := range a.Probes {
+			if p.Outcome == OutcomeMismatch || p.Outcome == OutcomePassword {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Plan returns a job for every port of every target address from every source,
+// skipping those the target's server or the source does not support.
+// Targets must be resolved first.
+func (s *Scanner) Plan(targets []string, ports []int) []*Job {
+	var list []*Job
+	for _, p := range ports {
+		for _, t := range targets {
+			for _, kind := range s.Kinds() {
+				caps := s.cfg.Capabilities[t]
+				if !caps.Supports(kind) || caps.Excludes(p) {
+					continue
+				}
+				for _, addr := range s.Addrs(t, kind) {
+					for _, src := range s.cfg.Sources {
+						if !src.Supports(kind) {
+							continue
+						}
+						list = append(list, &Job{
+							Target: t,
+							Addr:   addr,
+							Source: src,
+							Kind:   kind,
+							Port:   p,
+						})
+					}
+				}
+			}
+		}
+	}
+	return list
+}
+
+// Scan resolves the targets and tests every port of them, returning the completed jobs.
+func (s *Scanner) Scan(ctx context.Context, targets []string, ports []int) ([]*Job, error) {
+	if err := s.Resolve(ctx, targets); err != nil {
+		return nil, err
+	}
+	return s.Run(ctx, s.Plan(targets, ports), nil)
+}
+
+// Run tests the jobs with Parallel workers and returns them once completed.
+// Jobs with Resumed set are not tested and are returned as they are.
+// The handler, if not nil, is called with every event of the scan, ending with EventScanDone.
+// Canceling the context stops queuing jobs and attempts, attempts in progress finish or time out
+// and the jobs they complete are returned with those completed before.
+// Run may be called concurrently, every call has its own workers and channels.
+func (s *Scanner) Run(ctx context.Context, list []*Job, h Handler) ([]*Job, error) {
+	emit := syncHandler(h)
+	g, ctx := errgroup.WithContext(ctx)
+	// abort stops the scan once the handler fails on a completed job, as results are still drained
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+	jobs := make(chan *Job, 100)
+	results := make(chan *Job, 100)
+
+	// producers are the source and workers sending to results, which is closed once they all return
+	var producers sync.WaitGroup
+	producers.Add(1 + int(s.cfg.Parallel))
+
+	// start putting ports into queue
+	g.Go(func() error {
+		defer producers.Done()
+		defer close(jobs)
+		return jobSource(ctx, list, jobs, results, emit)
+	})
+
+	// start workers
+	for i := uint(0); i < s.cfg.Parallel; i++ {
+		g.Go(func() error {
+			defer producers.Done()
+			return s.worker(ctx, jobs, results, emit)
+		})
+	}
+
+	g.Go(func() error {
+		producers.Wait()
+		close(results)
+		return nil
+	})
+
+	// start results
+	var done []*Job
+	g.Go(func() error {
+		var err error
+		done, err = jobResults(results, emit, abort)
+		return err
+	})
+
+	err := g.Wait()
+	if herr := emit(Event{Type: EventScanDone, Jobs: done, Pending: pending(list, done), Err: err}); err == nil {
+		err = herr
+	}
+	return done, err
+}
+
+// pending returns the jobs of list that are not in done, in order.
+func pending(list, done []*Job) []*Job {
+	if len(done) == len(list) {
+		return nil
+	}
+	completed := make(map[*Job]bool, len(done))
+	for _, j := range done {
+		completed[j] = true
+	}
+	var rest []*Job
+	for _, j := range list {
+		if !completed[j] {
+			rest = append(rest, j)
+		}
+	}
+	return rest
+}
+
+// jobSource sends the jobs to the jobs channel until all are sent or the context is canceled.
+// Resumed jobs are sent directly to the results channel. The queued event is emitted before
+// the job is sent, so it comes before any other event of the job.
+func jobSource(ctx context.Context, list []*Job, jobs, results chan<- *Job, emit Handler) error {
+	for _, j := range list {
+		out := jobs
+		if j.Resumed {
+			out = results
+		}
+		if err := emit(Event{Type: EventJobQueued, Job: j}); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case out <- j:
+		}
+	}
+	return nil
+}
+
+// worker processes jobs from the jobs channel and sends results to the results channel.
+// It performs the actual port connectivity tests and retries failed attempts.
+// It returns when the jobs channel is closed or the context is canceled, dropping the job in progress.
+func (s *Scanner) worker(ctx context.Context, jobs <-chan *Job, results chan<- *Job, emit Handler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case j, ok := <-jobs:
+			if !ok {
+				// done
+				return nil
+			}
+			if err := emit(Event{Type: EventJobStarted, Job: j}); err != nil {
+				return err
+			}
+
+			// an attempt in progress when the scan is canceled finishes or times out
+			probeCtx := context.WithoutCancel(ctx)
+			try := func() (Attempt, error) {
+				var a Attempt
+				switch {
+				case strings.HasPrefix(j.Kind, "tcp"):
+					a.Open, a.Probes = s.probeTCPMulti(probeCtx, j.Source, j.Addr, j.Port, j.Kind)
+				case strings.HasPrefix(j.Kind, "udp"):
+					a.Open, a.Probes = s.probeUDPMulti(probeCtx, j.Source, j.Addr, j.Port, j.Kind)
+				default:
+					return a, fmt.Errorf("unknown kind: %s", j.Kind)
+				}
+				return a, nil
+			}
+
+			n := s.retries(j.Kind)
+			for i := uint(0); i < n && !j.Open; i++ {
+				if !sleepContext(ctx, s.backoffDelay(i)) || ctx.Err() != nil {
+					// the job is not complete until an attempt finds it open or none are left
+					return nil
+				}
+				a, err := try()
+				if err != nil {
+					return err
+				}
+				j.Attempts = append(j.Attempts, a)
+				j.Open = a.Open
+				s.log.Debug("attempt done", "kind", j.Kind, "remote", j.Addr, "port", j.Port, "attempt", len(j.Attempts), "open", a.Open)
+				if err := emit(Event{Type: EventAttemptDone, Job: j, Attempt: a}); err != nil {
+					return err
+				}
+			}
+
+			// jobResults drains results until it is closed, so completed jobs are never dropped
+			results <- j
+		}
+	}
+}
+
+// jobResults collects completed jobs from the results channel and emits them until the channel is closed.
+// It returns every job completed, which is a partial list if the scan was canceled.
+// Once the handler returns an error, the scan is aborted and the remaining jobs are drained and discarded.
+func jobResults(results <-chan *Job, emit Handler, abort context.CancelFunc) ([]*Job, error) {
+	var done []*Job
+	var err error
+	for j := range results {
+		if err != nil {
+			continue
+		}
+		if err = emit(Event{Type: EventJobDone, Job: j}); err != nil {
+			abort()
+			continue
+		}
+		done = append(done, j)
+	}
+	return done, err
+}
@@ -0,0 +1,304 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPassword = "portquiz"
+
+// echoServer starts a TCP server answering every connection with the password after delay,
+// and returns its port.
+func echoServer(t *testing.T, delay time.Duration) int {
+	t.Helper()
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 256)
+				if _, err := c.Read(buf); err != nil {
+					return
+				}
+				time.Sleep(delay)
+				c.Write([]byte(testPassword))
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// newTestScanner returns a TCP Scanner with the given parallelism and a single attempt per job.
+func newTestScanner(t *testing.T, parallel uint) *Scanner {
+	t.Helper()
+	s, err := New(Config{
+		TCP:      true,
+		Password: testPassword,
+		Timeout:  2 * time.Second,
+		Parallel: parallel,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// testJobs returns n TCP jobs to the port on localhost.
+func testJobs(n, port int) []*Job {
+	list := make([]*Job, n)
+	for i := range list {
+		list[i] = &Job{Target: "127.0.0.1", Addr: "127.0.0.1", Kind: "tcp4", Port: port}
+	}
+	return list
+}
+
+// recorder records the events of a scan.
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(e Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+// count returns the number of events of the type.
+func (r *recorder) count(t EventType) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if e.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// checkOrder fails the test if an event of a job comes before its EventJobQueued
+// or after its EventJobDone, or if EventScanDone is not last.
+func (r *recorder) checkOrder(t *testing.T) {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queued := make(map[*Job]bool)
+	done := make(map[*Job]bool)
+	for i, e := range r.events {
+		if e.Type == EventScanDone {
+			if i != len(r.events)-1 {
+				t.Errorf("EventScanDone at %d of %d events", i, len(r.events))
+			}
+			continue
+		}
+		if done[e.Job] {
+			t.Errorf("%s after job-done", e.Type)
+		}
+		switch e.Type {
+		case EventJobQueued:
+			queued[e.Job] = true
+		case EventJobDone:
+			done[e.Job] = true
+			fallthrough
+		default:
+			if !queued[e.Job] {
+				t.Errorf("%s before job-queued", e.Type)
+			}
+		}
+	}
+}
+
+// checkSplit fails the test if done and pending are not a partition of list.
+func checkSplit(t *testing.T, list, done, pending []*Job) {
+	t.Helper()
+	if len(done)+len(pending) != len(list) {
+		t.Fatalf("%d done + %d pending, want %d jobs", len(done), len(pending), len(list))
+	}
+	seen := make(map[*Job]int)
+	for _, j := range append(append([]*Job{}, done...), pending...) {
+		seen[j]++
+	}
+	for _, j := range list {
+		if seen[j] != 1 {
+			t.Fatalf("job returned %d times", seen[j])
+		}
+	}
+}
+
+func TestRunCompletes(t *testing.T) {
+	port := echoServer(t, 0)
+	s := newTestScanner(t, 8)
+	list := testJobs(50, port)
+	var rec recorder
+
+	done, err := s.Run(context.Background(), list, rec.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(list) {
+		t.Fatalf("got %d jobs, want %d", len(done), len(list))
+	}
+	for _, j := range done {
+		if !j.Open || len(j.Attempts) != 1 {
+			t.Errorf("job open=%v attempts=%d, want open after 1 attempt", j.Open, len(j.Attempts))
+		}
+	}
+	for _, typ := range []EventType{EventJobQueued, EventJobStarted, EventAttemptDone, EventJobDone} {
+		if n := rec.count(typ); n != len(list) {
+			t.Errorf("%d %s events, want %d", n, typ, len(list))
+		}
+	}
+	last := rec.events[len(rec.events)-1]
+	if last.Type != EventScanDone || len(last.Jobs) != len(list) || len(last.Pending) != 0 || last.Err != nil {
+		t.Errorf("last event %s with %d jobs, %d pending, err %v", last.Type, len(last.Jobs), len(last.Pending), last.Err)
+	}
+	rec.checkOrder(t)
+}
+
+func TestRunCancel(t *testing.T) {
+	port := echoServer(t, 5*time.Millisecond)
+	s := newTestScanner(t, 4)
+	list := testJobs(200, port)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var rec recorder
+	var completed atomic.Int32
+
+	done, err := s.Run(ctx, list, func(e Event) error {
+		if e.Type == EventJobDone && completed.Add(1) == 10 {
+			cancel()
+		}
+		return rec.handle(e)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) < 10 || len(done) == len(list) {
+		t.Fatalf("got %d jobs, want a partial scan of at least 10", len(done))
+	}
+	for _, j := range done {
+		// attempts in progress when canceled finish, so returned jobs are complete
+		if !j.Open {
+			t.Errorf("returned job not open after %d attempts", len(j.Attempts))
+		}
+	}
+	last := rec.events[len(rec.events)-1]
+	if last.Type != EventScanDone {
+		t.Fatalf("last event %s, want %s", last.Type, EventScanDone)
+	}
+	checkSplit(t, list, done, last.Pending)
+	rec.checkOrder(t)
+}
+
+func TestRunHandlerErrorAborts(t *testing.T) {
+	port := echoServer(t, 5*time.Millisecond)
+	s := newTestScanner(t, 4)
+	list := testJobs(400, port)
+	errHandler := errors.New("handler failed")
+	var rec recorder
+
+	done, err := s.Run(context.Background(), list, func(e Event) error {
+		rec.handle(e)
+		if e.Type == EventJobDone {
+			return errHandler
+		}
+		return nil
+	})
+	if !errors.Is(err, errHandler) {
+		t.Fatalf("got error %v, want %v", err, errHandler)
+	}
+	if len(done) != 0 {
+		t.Errorf("got %d jobs, want none as the handler failed on the first", len(done))
+	}
+	// workers stop soon after the failure, well before every job is started
+	if n := rec.count(EventJobStarted); n >= len(list)/2 {
+		t.Errorf("%d of %d jobs started after the handler failed", n, len(list))
+	}
+	if n := rec.count(EventJobDone); n != 1 {
+		t.Errorf("%d job-done events, want 1", n)
+	}
+	last := rec.events[len(rec.events)-1]
+	if last.Type != EventScanDone || !errors.Is(last.Err, errHandler) {
+		t.Errorf("last event %s with err %v", last.Type, last.Err)
+	}
+}
+
+func TestRunResumed(t *testing.T) {
+	port := echoServer(t, 0)
+	s := newTestScanner(t, 2)
+	list := testJobs(20, port)
+	for i, j := range list {
+		if i%2 == 0 {
+			j.Resumed = true
+			j.Open = i%4 == 0
+		}
+	}
+	var rec recorder
+
+	done, err := s.Run(context.Background(), list, rec.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSplit(t, list, done, nil)
+	for i, j := range list {
+		switch {
+		case j.Resumed && len(j.Attempts) != 0:
+			t.Errorf("resumed job %d tested %d times", i, len(j.Attempts))
+		case j.Resumed && j.Open != (i%4 == 0):
+			t.Errorf("resumed job %d open=%v changed", i, j.Open)
+		case !j.Resumed && (!j.Open || len(j.Attempts) != 1):
+			t.Errorf("job %d open=%v attempts=%d", i, j.Open, len(j.Attempts))
+		}
+	}
+	if n := rec.count(EventJobStarted); n != len(list)/2 {
+		t.Errorf("%d job-started events, want %d", n, len(list)/2)
+	}
+	if n := rec.count(EventJobDone); n != len(list) {
+		t.Errorf("%d job-done events, want %d", n, len(list))
+	}
+	rec.checkOrder(t)
+}
+
+func TestRunConcurrent(t *testing.T) {
+	port := echoServer(t, time.Millisecond)
+	s := newTestScanner(t, 4)
+	const runs = 4
+	lists := make([][]*Job, runs)
+	results := make([][]*Job, runs)
+	errs := make([]error, runs)
+	var wg sync.WaitGroup
+	for i := range lists {
+		lists[i] = testJobs(30, port)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = s.Run(context.Background(), lists[i], nil)
+		}()
+	}
+	wg.Wait()
+	for i := range lists {
+		if errs[i] != nil {
+			t.Fatalf("run %d: %v", i, errs[i])
+		}
+		// every run returns exactly its own jobs
+		checkSplit(t, lists[i], results[i], nil)
+	}
+}
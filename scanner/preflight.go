@@ -0,0 +1,93 @@
+// Package scanner provides the pre-flight check.
+// It verifies the server is reachable and accepts the password before starting a scan.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Errors returned by Preflight, wrapped with the details of the failure.
+var (
+	ErrUnreachable      = errors.New("host unreachable")
+	ErrNotPortquiz      = errors.New("not a portquiz server")
+	ErrPasswordMismatch = errors.New("password mismatch")
+)
+
+// outcomeRank orders probe outcomes by how much they reveal about the server,
+// so the most specific failure is reported.
+var outcomeRank = map[Outcome]int{
+	OutcomeNone:        0,
+	OutcomeUnreachable: 1,
+	OutcomeNoReply:     2,
+	OutcomeRefused:     3,
+	OutcomeMismatch:    4,
+	OutcomePassword:    5,
+}
+
+// Preflight probes the target's control ports, which the server always answers on, and returns
+// an error wrapping ErrUnreachable, ErrNotPortquiz or ErrPasswordMismatch describing why the
+// server can not be scanned. TCP is used when enabled as its failures are more specific.
+// The first source is used.
+func (s *Scanner) Preflight(ctx context.Context, target string, ports []int) error {
+	proto := "udp"
+	if s.cfg.TCP {
+		proto = "tcp"
+	}
+	src := s.cfg.Sources[0]
+
+	best := Probe{}
+	bestPort, bestKind := 0, ""
+	for _, kind := range s.Kinds() {
+		if !strings.HasPrefix(kind, proto) || !s.cfg.Capabilities[target].Supports(kind) || !src.Supports(kind) {
+			continue
+		}
+		for _, port := range ports {
+			for i := uint(0); i < s.retries(kind); i++ {
+				if !sleepContext(ctx, s.backoffDelay(i)) {
+					return ctx.Err()
+				}
+				var open bool
+				var p Probe
+				if proto == "tcp" {
+					open, p = s.ProbeTCP(ctx, src, target, port, kind)
+				} else {
+					open, p = s.ProbeUDP(ctx, src, target, port, kind)
+				}
+				if open {
+					return nil
+				}
+				if outcomeRank[p.Outcome] > outcomeRank[best.Outcome] {
+					best, bestPort, bestKind = p, port, kind
+				}
+			}
+		}
+	}
+
+	portList := make([]string, 0, len(ports))
+	for _, p := range ports {
+		portList = append(portList, strconv.Itoa(p))
+	}
+	switch best.Outcome {
+	case OutcomePassword:
+		return fmt.Errorf("%w: portquiz server %s rejected the password on %s port %d",
+			ErrPasswordMismatch, target, bestKind, bestPort)
+	case OutcomeMismatch:
+		return fmt.Errorf("%w: %s answered on %s port %d without the password", ErrNotPortquiz, target, bestKind, bestPort)
+	case OutcomeRefused:
+		return fmt.Errorf("%w: %s refused %s port %d", ErrNotPortquiz, target, bestKind, bestPort)
+	case OutcomeNoReply:
+		if proto == "tcp" {
+			return fmt.Errorf("%w: %s accepted %s port %d but did not reply", ErrNotPortquiz, target, bestKind, bestPort)
+		}
+		// the server does not answer short UDP payloads with the wrong password
+		return fmt.Errorf("%w or password mismatch: no reply from %s on udp port(s) %s",
+			ErrUnreachable, target, strings.Join(portList, ","))
+	case OutcomeNone:
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w: could not reach %s on %s port(s) %s", ErrUnreachable, target, proto, strings.Join(portList, ","))
+}
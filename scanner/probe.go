@@ -0,0 +1,44 @@
+// Package scanner provides the probe result types.
+// A probe is a single connection to the server sending the magic string.
+package scanner
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// Outcome describes how a single probe ended.
+type Outcome int
+
+const (
+	OutcomeNone        Outcome = iota // The probe was not completed, such as when canceled
+	OutcomeOpen                       // The server answered with the magic string
+	OutcomeUnreachable                // The host could not be resolved or reached in time
+	OutcomeRefused                    // The host actively refused the connection
+	OutcomeNoReply                    // The connection was made but nothing was received
+	OutcomeMismatch                   // Something answered, but not a portquiz server
+	OutcomePassword                   // A portquiz server answered that the password is wrong
+)
+
+// Probe records the outcome and timing of a single connection to the server.
+type Probe struct {
+	Outcome   Outcome       // How the probe ended
+	Connect   time.Duration // Time taken to establish the connection
+	FirstByte time.Duration // Time from sending the magic string to receiving the first response
+	Total     time.Duration // Total round-trip time from connecting to receiving the response
+}
+
+// classifyReply returns the outcome for the data received from the server.
+func (s *Scanner) classifyReply(reply []byte) Outcome {
+	switch {
+	case len(reply) == 0:
+		return OutcomeNoReply
+	case bytes.HasPrefix(reply, s.magic):
+		return OutcomeOpen
+	case bytes.HasPrefix(reply, protocol.PasswordMismatchReply):
+		return OutcomePassword
+	}
+	return OutcomeMismatch
+}
@@ -0,0 +1,206 @@
+package scanner
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// fakeTCPServer starts a TCP server handling every connection with handle, and returns its port.
+// The connection is closed once handle returns.
+func fakeTCPServer(t *testing.T, handle func(c *net.TCPConn)) int {
+	t.Helper()
+	l, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// fakeUDPServer starts a UDP server answering every datagram with the reply returned by handle,
+// or nothing if it is nil, and returns its port.
+func fakeUDPServer(t *testing.T, handle func(data []byte) []byte) int {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, addr, err := c.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if reply := handle(buf[:n]); reply != nil {
+				c.WriteToUDP(reply, addr)
+			}
+		}
+	}()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+// closedPort returns a port on localhost nothing listens on for the network.
+func closedPort(t *testing.T, network string) int {
+	t.Helper()
+	var port int
+	switch network {
+	case "tcp4":
+		l, err := net.Listen(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port = l.Addr().(*net.TCPAddr).Port
+		l.Close()
+	case "udp4":
+		c, err := net.ListenPacket(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port = c.LocalAddr().(*net.UDPAddr).Port
+		c.Close()
+	}
+	return port
+}
+
+// read reads the probe from the connection, returning what was read.
+func read(c net.Conn) []byte {
+	buf := make([]byte, 256)
+	n, _ := c.Read(buf)
+	return buf[:n]
+}
+
+// probeScanners returns Scanners logging at debug and at info level, to check probes
+// have the same results whatever the log level.
+func probeScanners(t *testing.T) map[string]*Scanner {
+	t.Helper()
+	scanners := make(map[string]*Scanner)
+	for name, level := range map[string]slog.Level{"debug": slog.LevelDebug, "info": slog.LevelInfo} {
+		s, err := New(Config{
+			TCP:      true,
+			UDP:      true,
+			Password: testPassword,
+			Timeout:  300 * time.Millisecond,
+			Logger:   slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: level})),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanners[name] = s
+	}
+	return scanners
+}
+
+func TestProbeTCP(t *testing.T) {
+	tests := []struct {
+		name    string
+		port    func(t *testing.T) int
+		open    bool
+		outcome Outcome
+	}{
+		{"echo", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) { c.Write(read(c)) })
+		}, true, OutcomeOpen},
+		{"echo then close", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) {
+				c.Write(read(c))
+				c.CloseWrite()
+				time.Sleep(time.Second)
+			})
+		}, true, OutcomeOpen},
+		{"reset", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) {
+				read(c)
+				c.SetLinger(0)
+			})
+		}, false, OutcomeNoReply},
+		{"stall", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) { time.Sleep(time.Second) })
+		}, false, OutcomeNoReply},
+		{"half-close", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) {
+				read(c)
+				c.CloseWrite()
+				time.Sleep(time.Second)
+			})
+		}, false, OutcomeNoReply},
+		{"wrong password", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) {
+				read(c)
+				c.Write(protocol.PasswordMismatchReply)
+			})
+		}, false, OutcomePassword},
+		{"not portquiz", func(t *testing.T) int {
+			return fakeTCPServer(t, func(c *net.TCPConn) { c.Write([]byte("SSH-2.0-OpenSSH\r\n")) })
+		}, false, OutcomeMismatch},
+		{"closed", func(t *testing.T) int { return closedPort(t, "tcp4") }, false, OutcomeRefused},
+	}
+	scanners := probeScanners(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tt.port(t)
+			for level, s := range scanners {
+				open, p := s.ProbeTCP(context.Background(), Source{}, "127.0.0.1", port, "tcp4")
+				if open != tt.open || p.Outcome != tt.outcome {
+					t.Errorf("%s logging: got open=%v outcome=%d, want open=%v outcome=%d",
+						level, open, p.Outcome, tt.open, tt.outcome)
+				}
+			}
+		})
+	}
+}
+
+func TestProbeUDP(t *testing.T) {
+	tests := []struct {
+		name    string
+		port    func(t *testing.T) int
+		open    bool
+		outcome Outcome
+	}{
+		{"echo", func(t *testing.T) int {
+			return fakeUDPServer(t, func(data []byte) []byte { return data })
+		}, true, OutcomeOpen},
+		{"stall", func(t *testing.T) int {
+			return fakeUDPServer(t, func([]byte) []byte { return nil })
+		}, false, OutcomeNoReply},
+		{"wrong password", func(t *testing.T) int {
+			return fakeUDPServer(t, func([]byte) []byte { return protocol.PasswordMismatchReply })
+		}, false, OutcomePassword},
+		{"not portquiz", func(t *testing.T) int {
+			return fakeUDPServer(t, func([]byte) []byte { return []byte("hello") })
+		}, false, OutcomeMismatch},
+		{"closed", func(t *testing.T) int { return closedPort(t, "udp4") }, false, OutcomeRefused},
+	}
+	scanners := probeScanners(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := tt.port(t)
+			for level, s := range scanners {
+				open, p := s.ProbeUDP(context.Background(), Source{}, "127.0.0.1", port, "udp4")
+				if open != tt.open || p.Outcome != tt.outcome {
+					t.Errorf("%s logging: got open=%v outcome=%d, want open=%v outcome=%d",
+						level, open, p.Outcome, tt.open, tt.outcome)
+				}
+			}
+		})
+	}
+}
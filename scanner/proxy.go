@@ -0,0 +1,410 @@
+// Package scanner provides proxy support.
+// It tests ports through a SOCKS5 or HTTP CONNECT proxy to find which destination ports
+// the proxy permits. SOCKS5 proxies are also used for UDP with UDP ASSOCIATE.
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SOCKS5 protocol values, see RFC 1928 and RFC 1929.
+const (
+	socksVersion       = 0x05
+	socksAuthNone      = 0x00
+	socksAuthPassword  = 0x02
+	socksCmdConnect    = 0x01
+	socksCmdAssociate  = 0x03
+	socksAddrIPv4      = 0x01
+	socksAddrDomain    = 0x03
+	socksAddrIPv6      = 0x04
+	socksReplySuccess  = 0x00
+	socksReplyRefused  = 0x05
+	socksPasswordAuthV = 0x01
+)
+
+// socksReplyErrors describes the SOCKS5 reply codes.
+var socksReplyErrors = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// udpConn is a UDP connection to a destination, direct or relayed by a proxy.
+type udpConn interface {
+	net.Conn
+	SetReadBuffer(bytes int) error
+}
+
+// Proxy is a SOCKS5 or HTTP CONNECT proxy to test through.
+type Proxy struct {
+	scheme string // socks5 or http
+	addr   string // host:port of the proxy
+	user   *url.Userinfo
+}
+
+// ParseProxy parses a proxy URL of the form socks5://[user:pass@]host:port or http://[user:pass@]host:port.
+func ParseProxy(raw string) (*Proxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	p := &Proxy{scheme: u.Scheme, addr: u.Host, user: u.User}
+	switch u.Scheme {
+	case "socks5":
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Hostname(), "1080")
+		}
+	case "http":
+		if u.Port() == "" {
+			p.addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, must be socks5 or http", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing proxy host")
+	}
+	return p, nil
+}
+
+// SupportsUDP reports whether UDP can be tested through the proxy, always true without a proxy.
+func (p *Proxy) SupportsUDP() bool {
+	return p == nil || p.scheme == "socks5"
+}
+
+// Scheme returns the proxy type, socks5 or http.
+func (p *Proxy) Scheme() string {
+	return p.scheme
+}
+
+// dialTCP connects to addr over network from src, through the proxy if set.
+// Through a proxy the returned connection is the one to the proxy once the tunnel is established.
+func (s *Scanner) dialTCP(ctx context.Context, src Source, network, addr string) (net.Conn, error) {
+	p := s.cfg.Proxy
+	if p == nil {
+		return s.dial(ctx, src, network, addr)
+	}
+	conn, err := s.dial(ctx, src, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", p.addr, err)
+	}
+	err = s.handshake(ctx, conn, func() error {
+		if p.scheme == "http" {
+			return p.connectHTTP(conn, addr)
+		}
+		_, err := p.connectSOCKS(conn, socksCmdConnect, addr)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialUDP connects to addr over network from src, through the proxy's UDP relay if set.
+func (s *Scanner) dialUDP(ctx context.Context, src Source, network, addr string) (udpConn, error) {
+	p := s.cfg.Proxy
+	if p == nil {
+		conn, err := s.dial(ctx, src, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*net.UDPConn), nil
+	}
+	if !p.SupportsUDP() {
+		return nil, fmt.Errorf("%s proxy does not support UDP", p.scheme)
+	}
+	// host names are sent to the proxy to resolve
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the relay is only kept open by the proxy while the control connection is
+	ctrl, err := s.dial(ctx, src, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", p.addr, err)
+	}
+	var relay *net.UDPAddr
+	err = s.handshake(ctx, ctrl, func() error {
+		// the client address is not known before the relay is dialed, so leave it unspecified
+		bound, err := p.connectSOCKS(ctrl, socksCmdAssociate, "0.0.0.0:0")
+		if err != nil {
+			return err
+		}
+		relay, err = net.ResolveUDPAddr("udp", bound)
+		return err
+	})
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if relay.IP.IsUnspecified() {
+		// the proxy relays on the address the control connection reached it on
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	relayConn, err := s.dial(ctx, src, "udp", relay.String())
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("proxy UDP relay %s: %w", relay, err)
+	}
+	return &socksUDPConn{UDPConn: relayConn.(*net.UDPConn), ctrl: ctrl, header: socksUDPHeader(host, port)}, nil
+}
+
+// handshake runs fn to set up a tunnel on conn, bounded by the timeout and ctx.
+func (s *Scanner) handshake(ctx context.Context, conn net.Conn, fn func() error) error {
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// unblock the handshake when cancelled
+		conn.SetDeadline(time.Now())
+	})
+	err := fn()
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// connectHTTP asks an HTTP proxy to tunnel conn to addr with the CONNECT method.
+func (p *Proxy) connectHTTP(conn net.Conn, addr string) error {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if p.user != nil {
+		pass, _ := p.user.Password()
+		req += "Proxy-Authorization: Basic " + basicAuth(p.user.Username(), pass) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		return err
+	}
+
+	// read the response a byte at a time so no tunneled data is consumed
+	var resp []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(resp, []byte("\r\n\r\n")) {
+		if len(resp) > maxControlReply {
+			return errors.New("proxy response too long")
+		}
+		if _, err := conn.Read(b); err != nil {
+			return fmt.Errorf("proxy response: %w", err)
+		}
+		resp = append(resp, b[0])
+	}
+	status, _, _ := bytes.Cut(resp, []byte("\r\n"))
+	var proto string
+	var code int
+	if _, err := fmt.Sscanf(string(status), "%s %d", &proto, &code); err != nil {
+		return fmt.Errorf("invalid proxy response %q", status)
+	}
+	if code < 200 || code > 299 {
+		return fmt.Errorf("proxy CONNECT %s: %d %s", addr, code, http.StatusText(code))
+	}
+	return nil
+}
+
+// connectSOCKS negotiates authentication with a SOCKS5 proxy and sends cmd for addr.
+// It returns the address bound by the proxy for the command.
+func (p *Proxy) connectSOCKS(conn net.Conn, cmd byte, addr string) (string, error) {
+	methods := []byte{socksAuthNone}
+	if p.user != nil {
+		methods = append(methods, socksAuthPassword)
+	}
+	greeting := append([]byte{socksVersion, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return "", err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return "", fmt.Errorf("socks5 greeting: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return "", fmt.Errorf("not a SOCKS5 proxy, version %d", reply[0])
+	}
+	switch reply[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if err := p.authSOCKS(conn); err != nil {
+			return "", err
+		}
+	default:
+		return "", errors.New("socks5: no acceptable authentication method")
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", err
+	}
+	req := append([]byte{socksVersion, cmd, 0}, socksAddr(host, port)...)
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("socks5 reply: %w", err)
+	}
+	if header[1] != socksReplySuccess {
+		msg, ok := socksReplyErrors[header[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown reply %d", header[1])
+		}
+		if header[1] == socksReplyRefused {
+			// classified like a direct connection refused
+			return "", fmt.Errorf("socks5 %s: %w", addr, syscall.ECONNREFUSED)
+		}
+		return "", fmt.Errorf("socks5 %s: %s", addr, msg)
+	}
+	return readSOCKSAddr(conn)
+}
+
+// authSOCKS authenticates to a SOCKS5 proxy with the username and password of the proxy URL.
+func (p *Proxy) authSOCKS(conn net.Conn) error {
+	user := p.user.Username()
+	pass, _ := p.user.Password()
+	if len(user) > 255 || len(pass) > 255 {
+		return errors.New("socks5: username or password too long")
+	}
+	req := []byte{socksPasswordAuthV, byte(len(user))}
+	req = append(req, user...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("socks5 authentication: %w", err)
+	}
+	if reply[1] != 0 {
+		return errors.New("socks5: authentication failed")
+	}
+	return nil
+}
+
+// socksAddr encodes a host and port as a SOCKS5 address.
+func socksAddr(host string, port int) []byte {
+	var b []byte
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		b = append([]byte{socksAddrDomain, byte(len(host))}, host...)
+	case ip.To4() != nil:
+		b = append([]byte{socksAddrIPv4}, ip.To4()...)
+	default:
+		b = append([]byte{socksAddrIPv6}, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// readSOCKSAddr reads a SOCKS5 address and returns it as host:port.
+func readSOCKSAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+	var host []byte
+	switch atyp[0] {
+	case socksAddrIPv4:
+		host = make([]byte, net.IPv4len)
+	case socksAddrIPv6:
+		host = make([]byte, net.IPv6len)
+	case socksAddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(r, n); err != nil {
+			return "", err
+		}
+		host = make([]byte, n[0])
+	default:
+		return "", fmt.Errorf("socks5: unknown address type %d", atyp[0])
+	}
+	if _, err := io.ReadFull(r, host); err != nil {
+		return "", err
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	h := string(host)
+	if atyp[0] != socksAddrDomain {
+		h = net.IP(host).String()
+	}
+	return net.JoinHostPort(h, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksUDPHeader returns the header prefixed to datagrams relayed to host and port.
+func socksUDPHeader(host string, port int) []byte {
+	// reserved and fragment fields are zero, fragmentation is not supported
+	return append([]byte{0, 0, 0}, socksAddr(host, port)...)
+}
+
+// socksUDPConn is a UDP connection relayed by a SOCKS5 proxy.
+// Datagrams are encapsulated with the relay header, and the control connection
+// is held open for the lifetime of the relay.
+type socksUDPConn struct {
+	*net.UDPConn
+	ctrl   net.Conn
+	header []byte
+}
+
+// Write sends b to the destination through the relay.
+func (c *socksUDPConn) Write(b []byte) (int, error) {
+	if _, err := c.UDPConn.Write(append(bytes.Clone(c.header), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read reads a datagram relayed from the destination into b, without the relay header.
+func (c *socksUDPConn) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+len(c.header)+net.IPv6len)
+	n, err := c.UDPConn.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	if n < 3 {
+		return 0, errors.New("socks5: short relayed datagram")
+	}
+	r := bytes.NewReader(buf[3:n])
+	if _, err := readSOCKSAddr(r); err != nil {
+		return 0, fmt.Errorf("socks5: relayed datagram: %w", err)
+	}
+	return copy(b, buf[n-r.Len():n]), nil
+}
+
+// Close closes the relay and its control connection.
+func (c *socksUDPConn) Close() error {
+	return errors.Join(c.UDPConn.Close(), c.ctrl.Close())
+}
+
+// basicAuth returns the credentials for HTTP basic authentication.
+func basicAuth(user, pass string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+}
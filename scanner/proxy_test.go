@@ -0,0 +1,424 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProxyHost is a host name the fake proxies resolve to localhost.
+const testProxyHost = "quiz.test"
+
+// proxyResolve resolves a host as the fake proxies do.
+func proxyResolve(host string) string {
+	if host == testProxyHost {
+		return "127.0.0.1"
+	}
+	return host
+}
+
+// relay copies data both ways between the connections until either side is done.
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// serveProxy accepts connections on a localhost listener, handling each with handle,
+// and returns the address of the listener.
+func serveProxy(t *testing.T, handle func(c net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				handle(c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// fakeSOCKS5 starts a SOCKS5 proxy supporting CONNECT and UDP ASSOCIATE, requiring the
+// username and password if user is set, and returns its address.
+// Connections refused by the destination are answered with the refused reply code.
+func fakeSOCKS5(t *testing.T, user, pass string) string {
+	t.Helper()
+	return serveProxy(t, func(c net.Conn) {
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(c, greeting); err != nil {
+			return
+		}
+		methods := make([]byte, greeting[1])
+		if _, err := io.ReadFull(c, methods); err != nil {
+			return
+		}
+		if user == "" {
+			c.Write([]byte{socksVersion, socksAuthNone})
+		} else {
+			if !bytes.Contains(methods, []byte{socksAuthPassword}) {
+				c.Write([]byte{socksVersion, 0xff})
+				return
+			}
+			c.Write([]byte{socksVersion, socksAuthPassword})
+			r := bufio.NewReader(c)
+			auth := make([]byte, 2)
+			io.ReadFull(r, auth)
+			u := make([]byte, auth[1])
+			io.ReadFull(r, u)
+			n, _ := r.ReadByte()
+			p := make([]byte, n)
+			io.ReadFull(r, p)
+			if string(u) != user || string(p) != pass {
+				c.Write([]byte{socksPasswordAuthV, 1})
+				return
+			}
+			c.Write([]byte{socksPasswordAuthV, 0})
+		}
+
+		req := make([]byte, 3)
+		if _, err := io.ReadFull(c, req); err != nil {
+			return
+		}
+		addr, err := readSOCKSAddr(c)
+		if err != nil {
+			return
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		addr = net.JoinHostPort(proxyResolve(host), port)
+		switch req[1] {
+		case socksCmdConnect:
+			dst, err := net.Dial("tcp4", addr)
+			if err != nil {
+				c.Write([]byte{socksVersion, socksReplyRefused, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer dst.Close()
+			bound := socksAddr("127.0.0.1", dst.LocalAddr().(*net.TCPAddr).Port)
+			c.Write(append([]byte{socksVersion, socksReplySuccess, 0}, bound...))
+			relay(c, dst)
+		case socksCmdAssociate:
+			pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				return
+			}
+			defer pc.Close()
+			// an unspecified bound address means the relay is on the address the client reached
+			bound := socksAddr("0.0.0.0", pc.LocalAddr().(*net.UDPAddr).Port)
+			c.Write(append([]byte{socksVersion, socksReplySuccess, 0}, bound...))
+			go relayUDP(pc)
+			// the relay is kept open while the control connection is
+			io.Copy(io.Discard, c)
+		default:
+			c.Write([]byte{socksVersion, 0x07, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+		}
+	})
+}
+
+// relayUDP relays datagrams between the client of a SOCKS5 UDP association and its destinations,
+// stripping the header from the client's datagrams and adding it to the replies.
+func relayUDP(pc *net.UDPConn) {
+	var client *net.UDPAddr
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			client = from
+			r := bytes.NewReader(buf[3:n])
+			addr, err := readSOCKSAddr(r)
+			if err != nil {
+				continue
+			}
+			host, port, _ := net.SplitHostPort(addr)
+			dst, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(proxyResolve(host), port))
+			if err != nil {
+				continue
+			}
+			pc.WriteToUDP(buf[n-r.Len():n], dst)
+			continue
+		}
+		reply := append(socksUDPHeader(from.IP.String(), from.Port), buf[:n]...)
+		pc.WriteToUDP(reply, client)
+	}
+}
+
+// fakeHTTPProxy starts an HTTP CONNECT proxy requiring the basic credentials if user is set,
+// and returns its address. Connections refused by the destination are answered with 502.
+func fakeHTTPProxy(t *testing.T, user, pass string) string {
+	t.Helper()
+	return serveProxy(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect {
+			io.WriteString(c, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+			return
+		}
+		if user != "" && req.Header.Get("Proxy-Authorization") != "Basic "+basicAuth(user, pass) {
+			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		host, port, _ := net.SplitHostPort(req.Host)
+		dst, err := net.Dial("tcp4", net.JoinHostPort(proxyResolve(host), port))
+		if err != nil {
+			io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer dst.Close()
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		relay(c, dst)
+	})
+}
+
+// newProxyScanner returns a TCP and UDP Scanner testing through the proxy URL.
+func newProxyScanner(t *testing.T, proxyURL string) *Scanner {
+	t.Helper()
+	p, err := ParseProxy(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{
+		TCP:      true,
+		UDP:      true,
+		Password: testPassword,
+		Timeout:  time.Second,
+		Proxy:    p,
+		Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestProxyTCP(t *testing.T) {
+	echo := fakeTCPServer(t, func(c *net.TCPConn) { c.Write(read(c)) })
+	closed := closedPort(t, "tcp4")
+	socks := fakeSOCKS5(t, "", "")
+	socksAuth := fakeSOCKS5(t, "user", "secret")
+	httpProxy := fakeHTTPProxy(t, "", "")
+	httpAuth := fakeHTTPProxy(t, "user", "secret")
+
+	tests := []struct {
+		name    string
+		proxy   string
+		host    string
+		port    int
+		open    bool
+		outcome Outcome
+	}{
+		{"socks5 connect", "socks5://" + socks, "127.0.0.1", echo, true, OutcomeOpen},
+		{"socks5 host name", "socks5://" + socks, testProxyHost, echo, true, OutcomeOpen},
+		{"socks5 refused", "socks5://" + socks, "127.0.0.1", closed, false, OutcomeRefused},
+		{"socks5 auth", "socks5://user:secret@" + socksAuth, "127.0.0.1", echo, true, OutcomeOpen},
+		{"socks5 auth failed", "socks5://user:wrong@" + socksAuth, "127.0.0.1", echo, false, OutcomeUnreachable},
+		{"http connect", "http://" + httpProxy, "127.0.0.1", echo, true, OutcomeOpen},
+		{"http host name", "http://" + httpProxy, testProxyHost, echo, true, OutcomeOpen},
+		{"http refused", "http://" + httpProxy, "127.0.0.1", closed, false, OutcomeUnreachable},
+		{"http auth", "http://user:secret@" + httpAuth, "127.0.0.1", echo, true, OutcomeOpen},
+		{"http auth failed", "http://user:wrong@" + httpAuth, "127.0.0.1", echo, false, OutcomeUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newProxyScanner(t, tt.proxy)
+			open, p := s.ProbeTCP(context.Background(), Source{}, tt.host, tt.port, "tcp4")
+			if open != tt.open || p.Outcome != tt.outcome {
+				t.Errorf("got open=%v outcome=%d, want open=%v outcome=%d", open, p.Outcome, tt.open, tt.outcome)
+			}
+		})
+	}
+}
+
+func TestProxyDialErrors(t *testing.T) {
+	echo := fakeTCPServer(t, func(c *net.TCPConn) { c.Write(read(c)) })
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(echo))
+	tests := []struct {
+		name  string
+		proxy string
+		err   string
+	}{
+		{"socks5 auth failed", "socks5://user:wrong@" + fakeSOCKS5(t, "user", "secret"), "authentication failed"},
+		{"socks5 no credentials", "socks5://" + fakeSOCKS5(t, "user", "secret"), "no acceptable authentication method"},
+		{"http auth failed", "http://user:wrong@" + fakeHTTPProxy(t, "user", "secret"), "407"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newProxyScanner(t, tt.proxy)
+			c, err := s.dialTCP(context.Background(), Source{}, "tcp4", addr)
+			if err == nil {
+				c.Close()
+				t.Fatal("dial succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %q, want it to mention %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestProxyUDP(t *testing.T) {
+	echo := fakeUDPServer(t, func(data []byte) []byte { return data })
+	silent := fakeUDPServer(t, func([]byte) []byte { return nil })
+	socks := fakeSOCKS5(t, "", "")
+	tests := []struct {
+		name    string
+		host    string
+		port    int
+		open    bool
+		outcome Outcome
+	}{
+		{"echo", "127.0.0.1", echo, true, OutcomeOpen},
+		{"host name", testProxyHost, echo, true, OutcomeOpen},
+		{"no reply", "127.0.0.1", silent, false, OutcomeNoReply},
+	}
+	s := newProxyScanner(t, "socks5://"+socks)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, p := s.ProbeUDP(context.Background(), Source{}, tt.host, tt.port, "udp4")
+			if open != tt.open || p.Outcome != tt.outcome {
+				t.Errorf("got open=%v outcome=%d, want open=%v outcome=%d", open, p.Outcome, tt.open, tt.outcome)
+			}
+		})
+	}
+
+	hs := newProxyScanner(t, "http://"+fakeHTTPProxy(t, "", ""))
+	if _, err := hs.dialUDP(context.Background(), Source{}, "udp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(echo))); err == nil {
+		t.Error("UDP through an HTTP proxy succeeded")
+	}
+}
+
+// relayPair returns a socksUDPConn to the destination host and port, and the UDP socket of its relay.
+func relayPair(t *testing.T, host string, port int) (*socksUDPConn, *net.UDPConn) {
+	t.Helper()
+	relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { relay.Close() })
+	uc, err := net.DialUDP("udp4", nil, relay.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctrl, _ := net.Pipe()
+	c := &socksUDPConn{UDPConn: uc, ctrl: ctrl, header: socksUDPHeader(host, port)}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(time.Second))
+	relay.SetDeadline(time.Now().Add(time.Second))
+	return c, relay
+}
+
+func TestSOCKSUDPConn(t *testing.T) {
+	tests := []struct {
+		name   string
+		host   string
+		header []byte // header the relay expects on sent datagrams
+	}{
+		{"ipv4", "192.0.2.1", []byte{0, 0, 0, socksAddrIPv4, 192, 0, 2, 1, 0x05, 0x39}},
+		{"ipv6", "2001:db8::1", append(append([]byte{0, 0, 0, socksAddrIPv6}, net.ParseIP("2001:db8::1")...), 0x05, 0x39)},
+		{"domain", testProxyHost, append(append([]byte{0, 0, 0, socksAddrDomain, byte(len(testProxyHost))}, testProxyHost...), 0x05, 0x39)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, relay := relayPair(t, tt.host, 1337)
+
+			// Write adds the header
+			if n, err := c.Write([]byte("probe")); err != nil || n != len("probe") {
+				t.Fatalf("Write returned %d, %v", n, err)
+			}
+			buf := make([]byte, 512)
+			n, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := append(bytes.Clone(tt.header), "probe"...); !bytes.Equal(buf[:n], want) {
+				t.Errorf("relay got %v, want %v", buf[:n], want)
+			}
+
+			// Read strips the header, whatever the address type of the reply
+			for _, header := range [][]byte{socksUDPHeader("192.0.2.1", 1337), socksUDPHeader("2001:db8::1", 1337), tt.header} {
+				relay.WriteToUDP(append(bytes.Clone(header), "reply"...), from)
+				got := make([]byte, 256)
+				n, err := c.Read(got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got[:n]) != "reply" {
+					t.Errorf("Read got %q, want %q", got[:n], "reply")
+				}
+			}
+		})
+	}
+}
+
+func TestSOCKSUDPConnInvalid(t *testing.T) {
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, 1337)
+	tests := []struct {
+		name     string
+		datagram []byte
+	}{
+		{"short", []byte{0, 0}},
+		{"unknown address type", append([]byte{0, 0, 0, 0x09, 1, 2, 3, 4}, port...)},
+		{"truncated address", []byte{0, 0, 0, socksAddrIPv6, 0x20, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, relay := relayPair(t, "127.0.0.1", 1337)
+			c.Write([]byte("probe"))
+			buf := make([]byte, 512)
+			_, from, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			relay.WriteToUDP(tt.datagram, from)
+			if n, err := c.Read(make([]byte, 256)); err == nil {
+				t.Errorf("Read returned %d bytes of an invalid datagram", n)
+			}
+		})
+	}
+}
+
+func TestSOCKSAddr(t *testing.T) {
+	for _, addr := range []string{"192.0.2.1:80", "[2001:db8::1]:443", testProxyHost + ":53"} {
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		got, err := readSOCKSAddr(bytes.NewReader(socksAddr(host, p)))
+		if err != nil || got != addr {
+			t.Errorf("%s: got %q, %v", addr, got, err)
+		}
+	}
+	if _, err := readSOCKSAddr(bytes.NewReader(fmt.Appendf(nil, "%c", 0x09))); err == nil {
+		t.Error("unknown address type accepted")
+	}
+}
@@ -0,0 +1,67 @@
+// Package scanner provides target address resolution.
+// Targets are resolved once before scanning so every job is pinned to a concrete IP.
+// When testing through a proxy, host names are left for the proxy to resolve.
+package scanner
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Resolve resolves the addresses of every target, replacing those previously resolved.
+// Through a proxy host names are not resolved, as local DNS is often unavailable where a proxy is required.
+func (s *Scanner) Resolve(ctx context.Context, targets []string) error {
+	for _, t := range targets {
+		if s.proxyResolves(t) {
+			delete(s.addrs, t)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, t)
+		cancel()
+		if err != nil {
+			return err
+		}
+		ips := make([]net.IP, 0, len(addrs))
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+		s.addrs[t] = ips
+	}
+	return nil
+}
+
+// proxyResolves reports whether the target is a host name left for the proxy to resolve.
+func (s *Scanner) proxyResolves(target string) bool {
+	return s.cfg.Proxy != nil && net.ParseIP(target) == nil
+}
+
+// Resolved returns the resolved addresses of the target, in resolver order.
+// It is empty for host names left for the proxy to resolve.
+func (s *Scanner) Resolved(target string) []net.IP {
+	return s.addrs[target]
+}
+
+// Addrs returns the addresses of the target to test for the kind.
+// Only the first address of the kind's IP version is used unless AllAddrs is set.
+// Host names left for the proxy to resolve are tested as is, whatever the kind's IP version.
+func (s *Scanner) Addrs(target, kind string) []string {
+	if s.proxyResolves(target) {
+		return []string{target}
+	}
+	var list []string
+	for _, ip := range s.addrs[target] {
+		switch {
+		case strings.HasSuffix(kind, "4") && ip.To4() == nil:
+			continue
+		case strings.HasSuffix(kind, "6") && ip.To4() != nil:
+			continue
+		}
+		list = append(list, ip.String())
+		if !s.cfg.AllAddrs {
+			break
+		}
+	}
+	return list
+}
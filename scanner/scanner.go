@@ -0,0 +1,134 @@
+// Package scanner tests which ports of a portquiz server are reachable.
+// A Scanner sends the password to every port of the server over TCP and UDP,
+// and a port is open when the server echoes it back.
+package scanner
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// Config configures a Scanner.
+type Config struct {
+	TCP  bool // Test TCP ports
+	UDP  bool // Test UDP ports
+	IPv4 bool // Force IPv4, test both IP versions when set with IPv6
+	IPv6 bool // Force IPv6, test both IP versions when set with IPv4
+
+	Password string        // Magic string the server echoes, must be the same on the server
+	Session  string        // ID the server records the probes under, 1-64 letters, digits, '.', '_' or '-', none when empty
+	Timeout  time.Duration // Time allowed for each connection, defaults to 5s
+	Multi    uint          // Number of probes an attempt makes to ensure larger streams work, defaults to 1
+	Parallel uint          // Number of jobs tested concurrently, defaults to 1
+
+	Retry      uint          // Number of attempts made for each job, defaults to 1
+	RetryTCP   uint          // Number of attempts for TCP jobs, overrides Retry when set
+	RetryUDP   uint          // Number of attempts for UDP jobs, overrides Retry when set
+	Backoff    time.Duration // Initial delay between attempts, doubled after each attempt
+	BackoffMax time.Duration // Maximum delay between attempts, unlimited when 0
+	Jitter     float64       // Fraction of the delay between attempts to randomize (0-1)
+
+	AllAddrs    bool               // Test every resolved address of the targets instead of the first for each IP version
+	Sources     []Source           // Local sources to test from, each is tested separately, defaults to the default route
+	SourcePorts protocol.PortRange // Local ports to send probes from, any when zero
+	Proxy       *Proxy             // Proxy to test through, nil to test directly
+
+	// Capabilities reported by each target's server, ports and protocols
+	// the server does not support are not tested. Targets may be missing.
+	Capabilities map[string]*protocol.Capabilities
+
+	Logger *slog.Logger // Logger for the outcome of every probe at debug level, defaults to slog.Default()
+}
+
+// Scanner tests ports of portquiz servers.
+type Scanner struct {
+	cfg      Config
+	magic    []byte
+	log      *slog.Logger
+	addrs    map[string][]net.IP // Resolved addresses of each target, in resolver order
+	nextPort atomic.Uint32       // Offset of the next source port to use within SourcePorts
+}
+
+// New returns a Scanner for the configuration, filling in defaults for unset fields.
+func New(cfg Config) (*Scanner, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Multi == 0 {
+		cfg.Multi = 1
+	}
+	if cfg.Parallel == 0 {
+		cfg.Parallel = 1
+	}
+	if cfg.Retry == 0 {
+		cfg.Retry = 1
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	if len(cfg.Sources) == 0 {
+		cfg.Sources = []Source{{}}
+	}
+	if cfg.Password == "" {
+		return nil, errors.New("password must be set")
+	}
+	if cfg.SourcePorts.Start != 0 && (cfg.SourcePorts.Start > cfg.SourcePorts.End || cfg.SourcePorts.End > 65535) {
+		return nil, errors.New("invalid source port range")
+	}
+	if cfg.Session != "" && !protocol.ValidSession(cfg.Session) {
+		return nil, errors.New("session must be 1 to 64 letters, digits, '.', '_' or '-'")
+	}
+	s := &Scanner{
+		cfg:   cfg,
+		magic: []byte(cfg.Password),
+		log:   cfg.Logger,
+		addrs: make(map[string][]net.IP),
+	}
+	if err := s.checkSources(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Sources returns the local sources tested from.
+func (s *Scanner) Sources() []Source {
+	return s.cfg.Sources
+}
+
+// versions returns the IP version suffixes to use based on the configuration.
+// Returns:
+//   - [""] for default (both IPv4 and IPv6)
+//   - ["4"] for IPv4 only
+//   - ["6"] for IPv6 only
+//   - ["4", "6"] for both explicitly
+func (s *Scanner) versions() []string {
+	if !s.cfg.IPv4 && !s.cfg.IPv6 {
+		return []string{""}
+	}
+	if s.cfg.IPv4 && !s.cfg.IPv6 {
+		return []string{"4"}
+	}
+	if !s.cfg.IPv4 && s.cfg.IPv6 {
+		return []string{"6"}
+	}
+	return []string{"4", "6"}
+}
+
+// Kinds returns the kinds of jobs created for each port, such as "tcp4" or "udp".
+func (s *Scanner) Kinds() []string {
+	var kinds []string
+	for _, v := range s.versions() {
+		if s.cfg.TCP {
+			kinds = append(kinds, "tcp"+v)
+		}
+		if s.cfg.UDP {
+			kinds = append(kinds, "udp"+v)
+		}
+	}
+	return kinds
+}
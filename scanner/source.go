@@ -0,0 +1,119 @@
+// Package scanner provides local source selection.
+// Probes can be sent from specific local addresses or interfaces to test egress per uplink,
+// and every port is tested once from each source given.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Source is a local address or interface probes are sent from.
+// The zero Source uses the system's default route.
+type Source struct {
+	IP        net.IP // Local address to bind to, nil for any
+	Interface string // Network interface to bind to, empty for any, only supported on Linux
+}
+
+// checkSources returns an error if a source can not be used with the configuration.
+func (s *Scanner) checkSources() error {
+	for _, src := range s.cfg.Sources {
+		if src.IP != nil && !slices.ContainsFunc(s.versions(), func(v string) bool { return src.Supports("ip" + v) }) {
+			return fmt.Errorf("%s does not match the forced IP version", src.IP)
+		}
+		if src.Interface == "" {
+			continue
+		}
+		if !canBindToDevice {
+			return errors.New("binding to an interface is only supported on Linux")
+		}
+		if _, err := net.InterfaceByName(src.Interface); err != nil {
+			return fmt.Errorf("%s: %w", src.Interface, err)
+		}
+	}
+	return nil
+}
+
+// CheckSources verifies every source can be bound to, so an address that is not assigned locally
+// or an interface needing privileges is reported before scanning instead of as closed ports.
+func (s *Scanner) CheckSources() error {
+	for _, src := range s.cfg.Sources {
+		if src.IP == nil && src.Interface == "" {
+			continue
+		}
+		network, addr := "udp", ":0"
+		if src.IP != nil {
+			network = "udp6"
+			if src.IP.To4() != nil {
+				network = "udp4"
+			}
+			addr = net.JoinHostPort(src.IP.String(), "0")
+		}
+		lc := net.ListenConfig{Control: s.dialer(src, network, 0).Control}
+		pc, err := lc.ListenPacket(context.Background(), network, addr)
+		switch {
+		case err == nil:
+			pc.Close()
+		case errors.Is(err, syscall.EADDRNOTAVAIL):
+			return fmt.Errorf("source %s is not a local address", src.IP)
+		case src.Interface != "" && (errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES)):
+			return fmt.Errorf("source %s: permission denied, binding to an interface may require root", src.Interface)
+		default:
+			return fmt.Errorf("source %s: %w", src, err)
+		}
+	}
+	return nil
+}
+
+// String returns the name of the source in output, empty for the zero source.
+func (src Source) String() string {
+	if src.IP != nil {
+		return src.IP.String()
+	}
+	return src.Interface
+}
+
+// Supports reports whether the source can send probes of the kind's IP version.
+func (src Source) Supports(kind string) bool {
+	switch {
+	case src.IP == nil:
+		return true
+	case strings.HasSuffix(kind, "4"):
+		return src.IP.To4() != nil
+	case strings.HasSuffix(kind, "6"):
+		return src.IP.To4() == nil
+	}
+	return true
+}
+
+// dialer returns a dialer for network bound to the source and the local port, 0 for any.
+// Fixed local ports are shared between concurrent probes with address and port reuse.
+func (s *Scanner) dialer(src Source, network string, port int) *net.Dialer {
+	d := &net.Dialer{Timeout: s.cfg.Timeout}
+	if src.IP != nil || port != 0 {
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: src.IP, Port: port}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: src.IP, Port: port}
+		}
+	}
+	if src.Interface != "" || port != 0 {
+		d.Control = func(network, address string, c syscall.RawConn) error {
+			if port != 0 {
+				if err := reuseAddr(c); err != nil {
+					return err
+				}
+			}
+			if src.Interface != "" {
+				return bindToDevice(c, src.Interface)
+			}
+			return nil
+		}
+	}
+	return d
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package scanner
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// soReusePort is SO_REUSEPORT, which the syscall package does not define on Linux.
+const soReusePort = 0xf
+
+// canBindToDevice reports whether sockets can be bound to an interface on this platform.
+const canBindToDevice = true
+
+// bindToDevice binds the socket to the network interface with SO_BINDTODEVICE,
+// so probes leave through it regardless of the routing table.
+func bindToDevice(c syscall.RawConn, iface string) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("binding to interface %s: %w", iface, err)
+	}
+	return nil
+}
+
+// reuseAddr allows the socket's local address and port to be shared with
+// other sockets, so concurrent probes can use the same source port.
+func reuseAddr(c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		if err == nil {
+			err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package scanner
+
+import (
+	"errors"
+	"syscall"
+)
+
+// canBindToDevice reports whether sockets can be bound to an interface on this platform.
+const canBindToDevice = false
+
+// bindToDevice is not supported outside of Linux.
+func bindToDevice(c syscall.RawConn, iface string) error {
+	return errors.New("binding to an interface is only supported on Linux")
+}
+
+// reuseAddr is not supported outside of Linux, so concurrent probes
+// from a fixed source port may find it busy.
+func reuseAddr(c syscall.RawConn) error {
+	return nil
+}
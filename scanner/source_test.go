@@ -0,0 +1,41 @@
+package scanner
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestCheckSources(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []Source
+		err     string
+	}{
+		{"default", nil, ""},
+		{"local", []Source{{IP: net.IPv4(127, 0, 0, 1)}}, ""},
+		{"not local", []Source{{IP: net.IPv4(127, 0, 0, 1)}, {IP: net.IPv4(192, 0, 2, 55)}}, "192.0.2.55 is not a local address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(Config{
+				TCP:      true,
+				Password: testPassword,
+				Sources:  tt.sources,
+				Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.CheckSources()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
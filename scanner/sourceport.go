@@ -0,0 +1,117 @@
+// Package scanner provides source port selection.
+// Some firewalls filter on the source port, so probes can be sent from a fixed
+// local port or from ports within a range.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pickSourcePort returns the next source port to send from, cycling through the range
+// so concurrent probes are spread across it. It returns 0 when no range is set.
+func (s *Scanner) pickSourcePort() int {
+	r := s.cfg.SourcePorts
+	if r.Start == 0 {
+		return 0
+	}
+	return r.Start + int(s.nextPort.Add(1)-1)%r.Size()
+}
+
+// busy reports whether err is caused by the local address being in use.
+func busy(err error) bool {
+	return errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.EADDRNOTAVAIL)
+}
+
+// dial connects to addr over network from the source and the next source port.
+// With a port range, busy ports are skipped until one is free.
+func (s *Scanner) dial(ctx context.Context, src Source, network, addr string) (net.Conn, error) {
+	r := s.cfg.SourcePorts
+	tries := 1
+	if r.Start != 0 {
+		tries = r.Size()
+	}
+	var err error
+	for range tries {
+		port := s.pickSourcePort()
+		var conn net.Conn
+		conn, err = s.dialer(src, network, port).DialContext(ctx, network, addr)
+		if err == nil {
+			if tc, ok := conn.(*net.TCPConn); ok && port != 0 {
+				// reset on close instead of lingering in TIME_WAIT, which would
+				// prevent the same source port connecting to the same destination again
+				if err := tc.SetLinger(0); err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		}
+		if !busy(err) {
+			return nil, err
+		}
+	}
+	switch {
+	case r.Start == 0:
+		// without a source port the address is busy for another reason
+		return nil, err
+	case r.Size() == 1:
+		return nil, fmt.Errorf("source port %d is busy: %w", r.Start, err)
+	}
+	return nil, fmt.Errorf("all source ports %d-%d are busy: %w", r.Start, r.End, err)
+}
+
+// CheckSourcePorts verifies a fixed source port can be bound from every source for every kind,
+// so a port used by another process or needing privileges is reported before scanning.
+// Both TCP and UDP are checked when neither is set, as either may be used.
+func (s *Scanner) CheckSourcePorts() error {
+	r := s.cfg.SourcePorts
+	if r.Start == 0 || r.Size() > 1 {
+		return nil
+	}
+	kinds := s.Kinds()
+	if !s.cfg.TCP && !s.cfg.UDP {
+		for _, v := range s.versions() {
+			kinds = append(kinds, "tcp"+v, "udp"+v)
+		}
+	}
+	for _, src := range s.cfg.Sources {
+		for _, kind := range kinds {
+			if !src.Supports(kind) {
+				continue
+			}
+			ip := ""
+			if src.IP != nil {
+				ip = src.IP.String()
+			}
+			addr := net.JoinHostPort(ip, strconv.Itoa(r.Start))
+			lc := net.ListenConfig{Control: s.dialer(src, kind, r.Start).Control}
+			var err error
+			if strings.HasPrefix(kind, "udp") {
+				var pc net.PacketConn
+				if pc, err = lc.ListenPacket(context.Background(), kind, addr); err == nil {
+					pc.Close()
+				}
+			} else {
+				var l net.Listener
+				if l, err = lc.Listen(context.Background(), kind, addr); err == nil {
+					l.Close()
+				}
+			}
+			switch {
+			case errors.Is(err, syscall.EACCES):
+				return fmt.Errorf("source port %d: permission denied, ports below 1024 may require root", r.Start)
+			case busy(err):
+				return fmt.Errorf("source port %d is busy on %s: %w", r.Start, kind, err)
+			case err != nil:
+				return fmt.Errorf("source port %d: %w", r.Start, err)
+			}
+		}
+	}
+	return nil
+}
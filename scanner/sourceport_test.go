@@ -0,0 +1,51 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+func TestDialAddressUnavailable(t *testing.T) {
+	// binding to an address that is not local fails with EADDRNOTAVAIL like a busy port
+	src := Source{IP: net.IPv4(192, 0, 2, 55)}
+	tests := []struct {
+		name  string
+		ports protocol.PortRange
+		err   string
+	}{
+		{"no source port", protocol.PortRange{}, ""},
+		{"source port", protocol.PortRange{Start: 40000, End: 40000}, "source port 40000 is busy"},
+		{"source port range", protocol.PortRange{Start: 40000, End: 40001}, "all source ports 40000-40001 are busy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(Config{
+				TCP:         true,
+				Password:    testPassword,
+				SourcePorts: tt.ports,
+				Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = s.dial(context.Background(), src, "tcp4", "127.0.0.1:1")
+			if !errors.Is(err, syscall.EADDRNOTAVAIL) {
+				t.Fatalf("got error %v, want EADDRNOTAVAIL", err)
+			}
+			if tt.err == "" && strings.Contains(err.Error(), "source port") {
+				t.Errorf("got error %q mentioning a source port", err)
+			}
+			if tt.err != "" && !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
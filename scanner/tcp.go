@@ -0,0 +1,143 @@
+// Package scanner provides TCP connectivity testing functionality.
+// It implements functions to test if TCP ports are open on a remote server.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// probeTCPMulti tests a TCP port multiple times to ensure reliability.
+// It returns true only if all attempts succeed, false if any attempt fails,
+// along with the timing of every probe made.
+func (s *Scanner) probeTCPMulti(ctx context.Context, src Source, host string, port int, network string) (bool, []Probe) {
+	var probes []Probe
+	for try := uint(0); try < s.cfg.Multi; try++ {
+		// Check for cancellation before each attempt
+		select {
+		case <-ctx.Done():
+			return false, probes
+		default:
+		}
+
+		open, p := s.ProbeTCP(ctx, src, host, port, network)
+		probes = append(probes, p)
+		if !open {
+			return false, probes
+		}
+	}
+	return true, probes
+}
+
+// ProbeTCP tests if a single TCP port is open on the remote server host, sending from src.
+// It connects to the port, sends the magic string and session, and checks for a valid response.
+// The returned probe holds the connect, first byte, and total round-trip times.
+func (s *Scanner) ProbeTCP(ctx context.Context, src Source, host string, port int, network string) (bool, Probe) {
+	var p Probe
+	// Check for cancellation before starting
+	select {
+	case <-ctx.Done():
+		return false, p
+	default:
+	}
+
+	log := s.log.With("kind", network, "remote", host, "port", port)
+
+	// setup
+	addr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	if s.cfg.Proxy == nil {
+		// host names are resolved by the proxy when there is one
+		tcpAddr, err := net.ResolveTCPAddr(network, addr)
+		if err != nil {
+			log.Debug("TCP resolve error", "error", err)
+			p.Outcome = OutcomeUnreachable
+			return false, p
+		}
+		addr = tcpAddr.String()
+	}
+	start := time.Now()
+	connInterface, err := s.dialTCP(ctx, src, network, addr)
+	p.Connect = time.Since(start)
+	conn, ok := connInterface.(*net.TCPConn)
+	if !ok && err == nil {
+		// This shouldn't happen with TCP dialing, but handle it gracefully
+		log.Debug("TCP dial returned unexpected connection type")
+		return false, p
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || os.IsTimeout(err) {
+		// port is closed
+		log.Debug("CLOSED", "error", err)
+		p.Outcome = OutcomeUnreachable
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			p.Outcome = OutcomeRefused
+		}
+		return false, p
+	}
+	if err != nil {
+		log.Debug("TCP dial error", "error", err)
+		p.Outcome = OutcomeUnreachable
+		return false, p
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Debug("TCP connection close error", "error", err)
+		}
+	}()
+
+	// setup
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		log.Debug("TCP SetDeadline warning", "error", err)
+	}
+	if err := conn.SetNoDelay(true); err != nil {
+		log.Debug("TCP SetNoDelay warning", "error", err)
+	}
+	payload := protocol.Probe(s.cfg.Password, s.cfg.Session, port)
+	if err := conn.SetWriteBuffer(len(payload)); err != nil {
+		log.Debug("TCP SetWriteBuffer warning", "error", err)
+	}
+	if err := conn.SetReadBuffer(len(s.magic)); err != nil {
+		log.Debug("TCP SetReadBuffer warning", "error", err)
+	}
+
+	// send data
+	if err := conn.SetWriteDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		log.Debug("TCP SetWriteDeadline warning", "error", err)
+	}
+	sent := time.Now()
+	_, err = conn.Write(payload)
+	if err != nil {
+		log.Debug("write error", "error", err)
+		p.Outcome = OutcomeNoReply
+		return false, p
+	}
+
+	// receive data
+	buffer := make([]byte, 256)
+	n, err := conn.Read(buffer)
+	p.FirstByte = time.Since(sent)
+	p.Total = time.Since(start)
+	p.Outcome = s.classifyReply(buffer[:n])
+	if err != nil {
+		log.Debug("read error", "error", err)
+		if n == 0 {
+			return false, p
+		}
+		// a reply received before the error is still classified
+	}
+
+	if p.Outcome == OutcomeOpen {
+		log.Debug("OPEN")
+		return true, p
+	} else {
+		log.Debug("unexpected reply", "data", string(buffer[:n]))
+	}
+
+	return false, p
+}
@@ -0,0 +1,131 @@
+// Package scanner provides UDP connectivity testing functionality.
+// It implements functions to test if UDP ports are open on a remote server.
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/lanrat/portquiz/protocol"
+)
+
+// probeUDPMulti tests a UDP port multiple times to ensure reliability.
+// It returns true only if all attempts succeed, false if any attempt fails,
+// along with the timing of every probe made.
+func (s *Scanner) probeUDPMulti(ctx context.Context, src Source, host string, port int, network string) (bool, []Probe) {
+	var probes []Probe
+	for try := uint(0); try < s.cfg.Multi; try++ {
+		// Check for cancellation before each attempt
+		select {
+		case <-ctx.Done():
+			return false, probes
+		default:
+		}
+
+		open, p := s.ProbeUDP(ctx, src, host, port, network)
+		probes = append(probes, p)
+		if !open {
+			return false, probes
+		}
+	}
+	return true, probes
+}
+
+// ProbeUDP tests if a single UDP port is open on the remote server host, sending from src.
+// It sends the magic string and session via UDP and checks for a valid response.
+// The returned probe holds the connect, first byte, and total round-trip times.
+func (s *Scanner) ProbeUDP(ctx context.Context, src Source, host string, port int, network string) (bool, Probe) {
+	var p Probe
+	// Check for cancellation before starting
+	select {
+	case <-ctx.Done():
+		return false, p
+	default:
+	}
+
+	log := s.log.With("kind", network, "remote", host, "port", port)
+
+	// setup
+	start := time.Now()
+	conn, err := s.dialUDP(ctx, src, network, net.JoinHostPort(host, fmt.Sprintf("%d", port)))
+	p.Connect = time.Since(start)
+	if err != nil {
+		log.Debug("UDP dial error", "error", err)
+		p.Outcome = OutcomeUnreachable
+		return false, p
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			log.Debug("UDP connection close error", "error", err)
+		}
+	}()
+
+	// tuning
+	if err := conn.SetDeadline(time.Now().Add(s.cfg.Timeout)); err != nil {
+		log.Debug("UDP SetDeadline warning", "error", err)
+	}
+	payload := protocol.Probe(s.cfg.Password, s.cfg.Session, port)
+	if err := conn.SetReadBuffer(len(payload) * 2); err != nil {
+		log.Debug("UDP SetReadBuffer warning", "error", err)
+	}
+
+	// Check for cancellation before send
+	select {
+	case <-ctx.Done():
+		return false, p
+	default:
+	}
+
+	// send data
+	sent := time.Now()
+	_, err = conn.Write(payload)
+	if err != nil {
+		log.Debug("write error", "error", err)
+		p.Outcome = OutcomeNoReply
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			p.Outcome = OutcomeRefused
+		}
+		return false, p
+	}
+
+	// Check for cancellation before receive
+	select {
+	case <-ctx.Done():
+		return false, p
+	default:
+	}
+
+	// receive data
+	buffer := make([]byte, 256)
+	n, err := conn.Read(buffer)
+	p.FirstByte = time.Since(sent)
+	p.Total = time.Since(start)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// port is closed
+		log.Debug("CLOSED", "error", err)
+		p.Outcome = OutcomeRefused
+		return false, p
+	}
+	p.Outcome = s.classifyReply(buffer[:n])
+	if err != nil {
+		log.Debug("read error", "error", err)
+		if n == 0 {
+			return false, p
+		}
+		// a reply received before the error is still classified
+	}
+
+	// check status
+	if p.Outcome == OutcomeOpen {
+		log.Debug("OPEN")
+		return true, p
+	} else {
+		log.Debug("unexpected reply", "data", string(buffer[:n]))
+	}
+
+	return false, p
+}
@@ -5,10 +5,12 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
+	"github.com/lanrat/portquiz/protocol"
 )
 
 // ip4t holds the IPv4 iptables instance for managing firewall rules.
@@ -26,18 +28,71 @@ var fw6Rules [][]string
 // insertRulePos specifies the position where iptables rules should be inserted.
 const insertRulePos = 1
 
+// maxMultiportPorts is the maximum number of ports an iptables multiport match accepts,
+// where a range counts as two.
+const maxMultiportPorts = 15
+
+// multiportList formats the ranges as an iptables multiport port list.
+// It returns an error if there are more ports than a multiport match accepts.
+func multiportList(ranges []protocol.PortRange) (string, error) {
+	count := 0
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		count++
+		if r.Start != r.End {
+			count++
+			parts = append(parts, fmt.Sprintf("%d:%d", r.Start, r.End))
+			continue
+		}
+		parts = append(parts, fmt.Sprint(r.Start))
+	}
+	if count > maxMultiportPorts {
+		return "", fmt.Errorf("too many excluded ports, iptables allows %d where a range counts as 2", maxMultiportPorts)
+	}
+	return strings.Join(parts, ","), nil
+}
+
 // newRule creates a new iptables DNAT rule for the specified IP, port, and protocol.
+// Ports given by -exclude are left out of the rule so they are not redirected.
 // It returns a slice of iptables rule arguments that can be used with the iptables library.
-func newRule(ip, port, proto string) []string {
+func newRule(ip, port, proto string) ([]string, error) {
 	fwComment := *magicString
-	return []string{
+	rule := []string{
 		"--destination", ip,
 		"-p", proto,
+	}
+	if len(excludedPorts) > 0 {
+		ports, err := multiportList(excludedPorts)
+		if err != nil {
+			return nil, err
+		}
+		rule = append(rule, "-m", "multiport", "!", "--dports", ports)
+	}
+	return append(rule,
 		"-j", "DNAT",
-		"--to-destination", ":" + port, // Correctly format the destination
+		"--to-destination", ":"+port, // Correctly format the destination
 		"-m", "comment",
 		"--comment", fwComment,
+	), nil
+}
+
+// newRules creates the iptables DNAT rules for the enabled protocols.
+func newRules(ip, port string) ([][]string, error) {
+	var rules [][]string
+	for _, proto := range []struct {
+		name    string
+		enabled bool
+	}{{"tcp", *tcp}, {"udp", *udp}} {
+		if !proto.enabled {
+			continue
+		}
+		rule, err := newRule(ip, port, proto.name)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
 	}
+	return rules, nil
 }
 
 // addFWRules creates and applies iptables rules for the specified IP and port.
@@ -65,12 +120,11 @@ func addFWRules(ip, port string) error {
 		}
 
 		// add rules
-		if *tcp {
-			fw4Rules = append(fw4Rules, newRule(ip, port, "tcp"))
-		}
-		if *udp {
-			fw4Rules = append(fw4Rules, newRule(ip, port, "udp"))
+		rules, err := newRules(ip, port)
+		if err != nil {
+			return err
 		}
+		fw4Rules = append(fw4Rules, rules...)
 		for _, rule := range fw4Rules {
 			err := ip4t.InsertUnique("nat", "PREROUTING", insertRulePos, rule...)
 			if err != nil {
@@ -89,16 +143,13 @@ func addFWRules(ip, port string) error {
 		}
 
 		// add rules
-		if *tcp {
-			fw6Rules = append(fw6Rules, newRule(ip, port, "tcp"))
-		}
-		if *udp {
-			fw6Rules = append(fw6Rules, newRule(ip, port, "udp"))
+		rules, err := newRules(ip, port)
+		if err != nil {
+			return err
 		}
+		fw6Rules = append(fw6Rules, rules...)
 		for _, rule := range fw6Rules {
-			if *verbose {
-				log.Printf("Adding firewall IPv6 rule %+v", rule)
-			}
+			slog.Debug("adding firewall IPv6 rule", "rule", rule)
 			err := ip6t.InsertUnique("nat", "PREROUTING", insertRulePos, rule...)
 			if err != nil {
 				return err
@@ -113,26 +164,18 @@ func addFWRules(ip, port string) error {
 func cleanupFW() error {
 	var err error
 	for _, rule := range fw4Rules {
-		if *verbose {
-			log.Printf("Removing firewall IPv4 rule %+v", rule)
-		}
+		slog.Debug("removing firewall IPv4 rule", "rule", rule)
 		err2 := ip4t.Delete("nat", "PREROUTING", rule...)
 		if err2 != nil {
-			if *verbose {
-				log.Printf("Error: %s", err2)
-			}
+			slog.Debug("removing firewall rule failed", "error", err2)
 			err = errors.Join(err, err2)
 		}
 	}
 	for _, rule := range fw6Rules {
-		if *verbose {
-			log.Printf("Removing firewall IPv6 rule %+v", rule)
-		}
+		slog.Debug("removing firewall IPv6 rule", "rule", rule)
 		err2 := ip6t.Delete("nat", "PREROUTING", rule...)
 		if err2 != nil {
-			if *verbose {
-				log.Printf("Error: %s", err2)
-			}
+			slog.Debug("removing firewall rule failed", "error", err2)
 			err = errors.Join(err, err2)
 		}
 	}
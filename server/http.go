@@ -0,0 +1,59 @@
+// Package main provides the HTTP endpoints of the portquiz server.
+// Prometheus metrics are served at /metrics and hit statistics at /stats,
+// sharing a listener when both are given the same address.
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpEndpoints maps listen addresses to the handlers served on them.
+type httpEndpoints map[string]*http.ServeMux
+
+// handle registers the handler for the path on the address.
+func (e httpEndpoints) handle(addr, path string, h http.Handler) {
+	if e[addr] == nil {
+		e[addr] = http.NewServeMux()
+	}
+	e[addr].Handle(path, h)
+}
+
+// serve listens on every address and serves its handlers until the context is canceled.
+// It returns an error without serving if any address can not be listened on.
+func (e httpEndpoints) serve(ctx context.Context) error {
+	listeners := make(map[string]net.Listener, len(e))
+	for addr := range e {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners[addr] = l
+	}
+	for addr, l := range listeners {
+		go serveHTTP(ctx, l, e[addr])
+	}
+	return nil
+}
+
+// serveHTTP serves the handler on the listener until the context is canceled.
+func serveHTTP(ctx context.Context, l net.Listener, h http.Handler) {
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		if err := srv.Close(); err != nil {
+			slog.Debug("HTTP server close error", "listen", l.Addr().String(), "error", err)
+		}
+	}()
+	slog.Info("starting HTTP server", "listen", l.Addr().String())
+	if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("HTTP server failed", "listen", l.Addr().String(), "error", err)
+	}
+}
@@ -5,37 +5,46 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"golang.org/x/sync/errgroup"
+	"github.com/lanrat/portquiz/internal/logging"
+	"github.com/lanrat/portquiz/protocol"
+	"github.com/lanrat/portquiz/quizserver"
 )
 
 var (
 	tcp         = flag.Bool("tcp", false, "start TCP server")
 	udp         = flag.Bool("udp", false, "start UDP server")
 	listenIPs   = flag.String("listen", "127.0.0.123", "comma separated list of IPs to listen on")
-	verbose     = flag.Bool("verbose", false, "enable verbose logging")
+	verbose     = flag.Bool("verbose", false, "enable verbose logging, same as -log-level debug")
+	logFormat   = flag.String("log-format", logging.FormatText, "log format, text or json")
+	logLevel    = flag.String("log-level", "info", "minimum level of messages to log, debug, info, warn or error")
 	timeout     = flag.Duration("timeout", time.Second*10, "amount of time for each connection")
 	port        = flag.Uint("port", 1337, "default port to listen on which will have traffic redirected to")
-	noIPTables  = flag.Bool("no-iptables", false, "disable automatically creating iptables rules")
+	noIPTables  = flag.Bool("no-iptables", false, "disable automatically creating iptables rules, for redirects set up by other means")
+	noRedirect  = flag.Bool("no-redirect", false, "declare that no ports are redirected to the server so clients only test -port, implies -no-iptables")
+	exclude     = flag.String("exclude", "", "comma separated list of ports or ranges (e.g. 22,8000-8100) not to redirect to the server")
 	magicString = flag.String("password", "portquiz", "magicString to use, must be the same on client/server")
+	metricsAddr = flag.String("metrics-listen", "", "address to serve Prometheus metrics on at /metrics, e.g. :9100, disabled when empty")
+	statsAddr   = flag.String("stats-listen", "", "address to serve per-port hit statistics on at /stats and scan session reports at /sessions, e.g. :9100, disabled when empty")
+	statsFile   = flag.String("stats-file", "", "file to load hit statistics from at startup and save them to periodically and on exit")
+	statsKeep   = flag.Duration("stats-retention", 24*time.Hour, "how long hit statistics of a port are kept after its last hit, 0 to keep them forever")
+	statsMax    = flag.Int("stats-max-hits", 200000, "maximum number of client, session and port hit statistics kept, 0 for no limit")
 	version     = flag.Bool("version", false, "show version information")
 )
 
 var (
-	g                *errgroup.Group
-	ctx              context.Context
-	magicStringBytes []byte
-	Version          = "dev"
+	excludedPorts []protocol.PortRange // Ports given by -exclude
+	Version       = "dev"
 )
 
 // main initializes and starts the portquiz server with the specified configuration.
@@ -48,66 +57,116 @@ func main() {
 		os.Exit(0)
 	}
 
-	magicStringBytes = []byte(*magicString)
+	logger, err := logging.New(*logFormat, *logLevel, *verbose)
+	if err != nil {
+		fatal("invalid logging flags", "error", err)
+	}
+	slog.SetDefault(logger)
 
 	if !*tcp && !*udp {
-		err := errors.New("must set TCP and/or UDP")
-		log.Fatal(err)
+		fatal("must set TCP and/or UDP")
+	}
+	if *noRedirect {
+		*noIPTables = true
+	}
+	if *statsMax < 0 {
+		fatal("-stats-max-hits must not be negative")
 	}
 
-	g, ctx = errgroup.WithContext(context.Background())
+	excludedPorts, err = protocol.ParsePortRanges(*exclude)
+	if err != nil {
+		fatal("invalid -exclude", "error", err)
+	}
 
-	// setup fw cleanup if killed
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	go func() {
-		// run cleanup on context canceled or interrupt
-		select {
-		case <-c:
-		case <-ctx.Done():
+	var listen []string
+	for _, ip := range strings.Split(*listenIPs, ",") {
+		if ip != "" {
+			listen = append(listen, ip)
 		}
-		cleanup()
-		os.Exit(0)
-	}()
+	}
 
-	listenPort := strconv.FormatUint(uint64(*port), 10)
+	var metrics *quizserver.Metrics
+	if *metricsAddr != "" {
+		metrics = quizserver.NewMetrics()
+	}
 
-	for _, ip := range strings.Split(*listenIPs, ",") {
-		if ip == "" {
-			continue
+	var stats *quizserver.Stats
+	if *statsAddr != "" || *statsFile != "" {
+		stats = quizserver.NewStats(*statsKeep, *statsMax)
+	}
+	if *statsFile != "" {
+		if err := stats.Load(*statsFile); err != nil {
+			fatal("loading -stats-file failed", "error", err)
 		}
-		listen := net.JoinHostPort(ip, listenPort)
+	}
 
-		if !*noIPTables {
+	srv, err := quizserver.New(quizserver.Config{
+		TCP:      *tcp,
+		UDP:      *udp,
+		Listen:   listen,
+		Port:     *port,
+		Password: *magicString,
+		Timeout:  *timeout,
+		Version:  Version,
+		Redirect: !*noRedirect,
+		Excluded: excludedPorts,
+		Logger:   logger,
+		Metrics:  metrics,
+		Stats:    stats,
+	})
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+
+	// stop serving, save the statistics and clean up the firewall rules if killed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	endpoints := make(httpEndpoints)
+	if metrics != nil {
+		endpoints.handle(*metricsAddr, "/metrics", metrics)
+	}
+	if *statsAddr != "" {
+		endpoints.handle(*statsAddr, "/stats", stats)
+		endpoints.handle(*statsAddr, "GET /sessions", http.HandlerFunc(stats.ServeSessions))
+		endpoints.handle(*statsAddr, "GET /sessions/{id}", http.HandlerFunc(stats.ServeSession))
+	}
+	if err := endpoints.serve(ctx); err != nil {
+		fatal("HTTP listen failed", "error", err)
+	}
+	if *statsFile != "" {
+		go saveStats(ctx, stats, *statsFile)
+	}
+
+	if !*noIPTables {
+		listenPort := strconv.FormatUint(uint64(*port), 10)
+		for _, ip := range listen {
 			err := addFWRules(ip, listenPort)
 			if err != nil {
-				log.Fatal(err)
+				fatal("adding firewall rules", "listen", ip, "error", err)
 			}
 		}
-
-		if *tcp {
-			g.Go(func() error { return tcpServer(ctx, listen) })
-		}
-
-		if *udp {
-			g.Go(func() error { return udpServer(ctx, listen) })
-		}
 	}
 
-	err := g.Wait()
-	if err != nil {
-		log.Fatal(err)
+	err = srv.Run(ctx)
+	cleanup(stats)
+	if err != nil && ctx.Err() == nil {
+		fatal("server failed", "error", err)
 	}
 }
 
-// cleanup removes all firewall rules created by the server and performs shutdown tasks.
-func cleanup() {
-	if *verbose {
-		log.Printf("Cleaning up for exit")
+// cleanup removes all firewall rules created by the server and performs shutdown tasks,
+// such as saving the statistics.
+func cleanup(stats *quizserver.Stats) {
+	slog.Debug("cleaning up for exit")
+	if *statsFile != "" {
+		if err := stats.Save(*statsFile); err != nil {
+			slog.Error("saving statistics failed", "path", *statsFile, "error", err)
+		}
 	}
 	if !*noIPTables {
 		if err := cleanupFW(); err != nil {
-			log.Printf("Cleanup firewall error: %s", err)
+			slog.Error("cleanup firewall error", "error", err)
 		}
 	}
 }
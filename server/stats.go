@@ -0,0 +1,30 @@
+// Package main provides persistence of the portquiz server hit statistics.
+// Statistics are loaded from the -stats-file at startup and saved to it periodically and on exit.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/lanrat/portquiz/quizserver"
+)
+
+// statsSaveInterval is how often the statistics are saved to the -stats-file.
+const statsSaveInterval = time.Minute
+
+// saveStats saves the statistics to path every statsSaveInterval until the context is canceled.
+func saveStats(ctx context.Context, st *quizserver.Stats, path string) {
+	t := time.NewTicker(statsSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := st.Save(path); err != nil {
+				slog.Error("saving statistics failed", "path", path, "error", err)
+			}
+		}
+	}
+}